@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// apiServer serves this conductor's view of the cluster over HTTP.
+type apiServer struct {
+	scheduler Scheduler
+	register  Register
+	cluster   Cluster
+}
+
+func NewAPIServer(s Scheduler, r Register, c Cluster) http.Handler {
+	api := &apiServer{
+		scheduler: s,
+		register:  r,
+		cluster:   c,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/manifests", api.get(api.manifests))
+	mux.HandleFunc("/containers", api.get(api.containers))
+	mux.HandleFunc("/services", api.get(api.services))
+	mux.HandleFunc("/hosts", api.get(api.hosts))
+	mux.HandleFunc("/reconcile", api.post(api.reconcile))
+	mux.HandleFunc("/release", api.post(api.release))
+	mux.HandleFunc("/cordon", api.post(api.cordon))
+	mux.HandleFunc("/uncordon", api.post(api.uncordon))
+	mux.HandleFunc("/drain", api.post(api.drain))
+	mux.HandleFunc("/metrics", metricsHandler)
+	return mux
+}
+
+// StartAPIServer serves the API at addr in background.
+func StartAPIServer(addr string, handler http.Handler) {
+	go func() {
+		err := http.ListenAndServe(addr, handler)
+		if err != nil {
+			logger.Op("api").Error("serving api failed", "addr", addr, "err", err)
+		}
+	}()
+}
+
+type apiHandler func(r *http.Request) (interface{}, error)
+
+func (api *apiServer) get(h apiHandler) http.HandlerFunc {
+	return api.handle("GET", h)
+}
+
+func (api *apiServer) post(h apiHandler) http.HandlerFunc {
+	return api.handle("POST", h)
+}
+
+func (api *apiServer) handle(method string, h apiHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		v, err := h(r)
+		if err != nil {
+			logger.Op("api").Warn("request failed", "path", r.URL.Path, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+}
+
+func (api *apiServer) manifests(r *http.Request) (interface{}, error) {
+	manifests, err := api.scheduler.Acquired()
+	if manifests == nil {
+		manifests = []*Manifest{}
+	}
+	return manifests, err
+}
+
+func (api *apiServer) containers(r *http.Request) (interface{}, error) {
+	return api.scheduler.Containers()
+}
+
+func (api *apiServer) services(r *http.Request) (interface{}, error) {
+	return api.register.Announced()
+}
+
+type hostsResponse struct {
+	Host  string         `json:"host"`
+	Order int            `json:"order"`
+	Loads map[string]int `json:"loads"`
+}
+
+func (api *apiServer) hosts(r *http.Request) (interface{}, error) {
+	order, err := api.cluster.HostLoadOrder(hostIP)
+	if err != nil {
+		return nil, err
+	}
+	loads, err := api.cluster.HostLoads()
+	if err != nil {
+		return nil, err
+	}
+	return hostsResponse{
+		Host:  hostIP,
+		Order: order,
+		Loads: loads,
+	}, nil
+}
+
+type resultResponse struct {
+	Result string `json:"result"`
+}
+
+func (api *apiServer) reconcile(r *http.Request) (interface{}, error) {
+	err := api.scheduler.Reconcile()
+	return resultResponse{"reconciled"}, err
+}
+
+// release releases the slot of the container given by the app and
+// container query parameters, or all slots if they are not given.
+func (api *apiServer) release(r *http.Request) (interface{}, error) {
+	app := r.FormValue("app")
+	container := r.FormValue("container")
+	if app == "" && container == "" {
+		err := api.scheduler.ReleaseAll()
+		return resultResponse{"released"}, err
+	}
+	err := api.scheduler.Release(app, container)
+	return resultResponse{"released"}, err
+}
+
+func (api *apiServer) cordon(r *http.Request) (interface{}, error) {
+	err := api.scheduler.Cordon()
+	return resultResponse{"cordoned"}, err
+}
+
+func (api *apiServer) uncordon(r *http.Request) (interface{}, error) {
+	err := api.scheduler.Uncordon()
+	return resultResponse{"uncordoned"}, err
+}
+
+func (api *apiServer) drain(r *http.Request) (interface{}, error) {
+	err := api.scheduler.Drain()
+	return resultResponse{"drained"}, err
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+type schedulerMock struct {
+	released []string
+}
+
+func (s *schedulerMock) Schedule(m *Manifest) error         { return nil }
+func (s *schedulerMock) Reconcile() error                   { return nil }
+func (s *schedulerMock) StartSchedulingLoop() chan struct{} { return nil }
+func (s *schedulerMock) Acquired() ([]*Manifest, error)     { return nil, nil }
+func (s *schedulerMock) Containers() (map[string]docker.APIContainers, error) {
+	return nil, nil
+}
+func (s *schedulerMock) Release(app, container string) error {
+	s.released = append(s.released, app+"/"+container)
+	return nil
+}
+func (s *schedulerMock) ReleaseAll() error {
+	s.released = append(s.released, "*")
+	return nil
+}
+func (s *schedulerMock) Cordon() error   { return nil }
+func (s *schedulerMock) Uncordon() error { return nil }
+func (s *schedulerMock) Drain() error    { return nil }
+func (s *schedulerMock) Stop()           {}
+func (s *schedulerMock) Leave() error    { return nil }
+
+type clusterMock struct {
+	loads map[string]int
+}
+
+func (c clusterMock) GetClusterIPs() []string              { return nil }
+func (c clusterMock) GetHosts() []string                   { return nil }
+func (c clusterMock) HostLoads() (map[string]int, error)   { return c.loads, nil }
+func (c clusterMock) HostLoadOrder(ip string) (int, error) { return 1, nil }
+
+func TestAPIServer(t *testing.T) {
+	defer func(ip string) { hostIP = ip }(hostIP)
+	hostIP = "10.0.0.1"
+	s := &schedulerMock{}
+	api := NewAPIServer(s, nil, clusterMock{map[string]int{"10.0.0.1": 2, "10.0.0.2": 1}})
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/hosts", nil)
+	api.ServeHTTP(w, r)
+	var hosts hostsResponse
+	json.Unmarshal(w.Body.Bytes(), &hosts)
+	if w.Code != http.StatusOK || hosts.Host != "10.0.0.1" || hosts.Order != 1 || hosts.Loads["10.0.0.2"] != 1 {
+		t.Error(w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/manifests", nil)
+	api.ServeHTTP(w, r)
+	if w.Body.String() != "[]\n" {
+		t.Error(w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/release", nil)
+	api.ServeHTTP(w, r)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Error(w.Code)
+	}
+
+	for _, url := range []string{"/release?app=blog&container=web", "/release"} {
+		w = httptest.NewRecorder()
+		r, _ = http.NewRequest("POST", url, nil)
+		api.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Error(url, w.Code)
+		}
+	}
+	if len(s.released) != 2 || s.released[0] != "blog/web" || s.released[1] != "*" {
+		t.Error(s.released)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// AppFile describes all containers of an app in one YAML document:
+//
+//	name: blog
+//	containers:
+//	  db:
+//	    image: postgres:9.4
+//	  web:
+//	    image: k2nr/blog
+//	    scale: 2
+//	    links: [db]
+//	    ports: ["80:8080"]
+//	    env: ["RAILS_ENV=production"]
+//
+// Keys of a container are the fields of the manifest in any case. Like
+// docker-compose, ports can be given as "[hostIP:]hostPort:containerPort[/protocol]",
+// env as a list of "KEY=value" and command as a string.
+type AppFile struct {
+	Name string
+	// Containers maps a container name to its manifest JSON.
+	Containers map[string]string
+}
+
+func ParseAppFile(data []byte) (*AppFile, error) {
+	var doc map[interface{}]interface{}
+	err := yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, err
+	}
+	name, _ := doc["name"].(string)
+	if name == "" {
+		return nil, errors.New("app file: name must not be empty")
+	}
+	containers, ok := doc["containers"].(map[interface{}]interface{})
+	if !ok || len(containers) == 0 {
+		return nil, errors.New("app file: containers must not be empty")
+	}
+
+	app := &AppFile{
+		Name:       name,
+		Containers: map[string]string{},
+	}
+	for k, v := range containers {
+		containerName := fmt.Sprint(k)
+		c, err := yamlToJSON(v)
+		if err != nil {
+			return nil, fmt.Errorf("app file: %s: %s", containerName, err)
+		}
+		fields, ok := c.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("app file: %s must be a map", containerName)
+		}
+		err = composeFields(fields)
+		if err != nil {
+			return nil, fmt.Errorf("app file: %s: %s", containerName, err)
+		}
+		value, err := json.Marshal(fields)
+		if err != nil {
+			return nil, err
+		}
+		app.Containers[containerName] = string(value)
+	}
+	return app, nil
+}
+
+// yamlToJSON converts maps decoded by yaml into maps encodable as JSON.
+func yamlToJSON(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for k, val := range v {
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("key %v must be a string", k)
+			}
+			converted, err := yamlToJSON(val)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = converted
+		}
+		return m, nil
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, val := range v {
+			converted, err := yamlToJSON(val)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = converted
+		}
+		return list, nil
+	}
+	return v, nil
+}
+
+// composeFields converts docker-compose like shorthands into manifest fields.
+func composeFields(fields map[string]interface{}) error {
+	for key, v := range fields {
+		switch strings.ToLower(key) {
+		case "command":
+			if s, ok := v.(string); ok {
+				fields[key] = strings.Fields(s)
+			}
+		case "env", "environment":
+			delete(fields, key)
+			env := map[string]interface{}{}
+			switch v := v.(type) {
+			case map[string]interface{}:
+				for k, val := range v {
+					env[k] = fmt.Sprint(val)
+				}
+			case []interface{}:
+				for _, e := range v {
+					parts := strings.SplitN(fmt.Sprint(e), "=", 2)
+					if len(parts) != 2 {
+						return fmt.Errorf("env %v must be KEY=value", e)
+					}
+					env[parts[0]] = parts[1]
+				}
+			}
+			fields["Env"] = env
+		case "ports":
+			list, ok := v.([]interface{})
+			if !ok {
+				continue
+			}
+			for i, p := range list {
+				s, ok := p.(string)
+				if !ok {
+					continue
+				}
+				port, err := parsePortSpec(s)
+				if err != nil {
+					return err
+				}
+				list[i] = port
+			}
+		}
+	}
+	return nil
+}
+
+// parsePortSpec parses "[hostIP:]hostPort:containerPort[/protocol]".
+func parsePortSpec(spec string) (Port, error) {
+	p := Port{Protocol: "tcp"}
+	s := spec
+	if i := strings.LastIndex(s, "/"); i >= 0 {
+		p.Protocol = s[i+1:]
+		s = s[:i]
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) == 3 {
+		p.HostIP = parts[0]
+		parts = parts[1:]
+	}
+	if len(parts) != 2 {
+		return p, fmt.Errorf("port %s must be [hostIP:]hostPort:containerPort[/protocol]", spec)
+	}
+	var err error
+	p.HostPort, err = strconv.Atoi(parts[0])
+	if err != nil {
+		return p, fmt.Errorf("port %s: %s", spec, err)
+	}
+	p.ContainerPort, err = strconv.Atoi(parts[1])
+	if err != nil {
+		return p, fmt.Errorf("port %s: %s", spec, err)
+	}
+	return p, nil
+}
+
+// Manifests returns valid manifests of the app ordered so that linked
+// containers come first.
+func (a *AppFile) Manifests() ([]*Manifest, error) {
+	manifests := map[string]*Manifest{}
+	var errs []string
+	for name, value := range a.Containers {
+		m, err := NewManifest(a.Name, name, value)
+		if err == nil {
+			err = m.Validate()
+		}
+		if err != nil {
+			errs = append(errs, name+": "+err.Error())
+			continue
+		}
+		manifests[name] = m
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return nil, errors.New(strings.Join(errs, "\n"))
+	}
+
+	names := []string{}
+	for name := range manifests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var ordered []*Manifest
+	state := map[string]int{} // 1: visiting, 2: visited
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case 1:
+			return errors.New("app file: circular links at " + name)
+		case 2:
+			return nil
+		}
+		state[name] = 1
+		m := manifests[name]
+		for _, l := range m.Container.Links {
+			if _, ok := manifests[l]; !ok {
+				// linked to a container applied before
+				continue
+			}
+			err := visit(l)
+			if err != nil {
+				return err
+			}
+		}
+		state[name] = 2
+		ordered = append(ordered, m)
+		return nil
+	}
+	for _, name := range names {
+		err := visit(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// Apply sets the manifests of the app to etcd, linked containers first.
+func (a *AppFile) Apply(cli EtcdInterface) error {
+	manifests, err := a.Manifests()
+	if err != nil {
+		return err
+	}
+	for _, m := range manifests {
+		_, err = cli.Set(m.ManifestKey(), a.Containers[m.ContainerName], 0)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
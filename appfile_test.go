@@ -0,0 +1,94 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testAppFile = `
+name: blog
+containers:
+  web:
+    image: k2nr/blog
+    scale: 2
+    links: [cache, db]
+    command: bundle exec rails s
+    ports: ["80:3000", "10.0.0.1:53:53/udp"]
+    env:
+      - RAILS_ENV=production
+    services:
+      http:
+        port: 3000
+        role: web
+  cache:
+    image: redis
+    links: [db]
+  db:
+    image: postgres:9.4
+    volumes:
+      - source: pgdata
+        target: /var/lib/postgresql/data
+`
+
+func TestParseAppFile(t *testing.T) {
+	app, err := ParseAppFile([]byte(testAppFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.Name != "blog" || len(app.Containers) != 3 {
+		t.Fatal(app)
+	}
+
+	manifests, err := app.Manifests()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	for _, m := range manifests {
+		order = append(order, m.ContainerName)
+	}
+	if !reflect.DeepEqual(order, []string{"db", "cache", "web"}) {
+		t.Error(order)
+	}
+
+	web := manifests[2].Container
+	if web.Image != "k2nr/blog" || web.Scale != 2 || web.Services["http"].Port != 3000 {
+		t.Error(web)
+	}
+	if !reflect.DeepEqual(web.Command, []string{"bundle", "exec", "rails", "s"}) {
+		t.Error(web.Command)
+	}
+	if web.Env["RAILS_ENV"] != "production" {
+		t.Error(web.Env)
+	}
+	expected := []Port{
+		{HostPort: 80, ContainerPort: 3000, Protocol: "tcp"},
+		{HostPort: 53, ContainerPort: 53, Protocol: "udp", HostIP: "10.0.0.1"},
+	}
+	if !reflect.DeepEqual(web.Ports, expected) {
+		t.Error(web.Ports)
+	}
+	if manifests[0].Container.Volumes[0].Source != "pgdata" {
+		t.Error(manifests[0].Container.Volumes)
+	}
+}
+
+func TestParseAppFileErrors(t *testing.T) {
+	expects := map[string]string{
+		"containers: {web: {image: nginx}}": "name",
+		"name: blog":                        "containers",
+		"name: blog\ncontainers: {web: {image: nginx, ports: [\"80\"]}}":                 "port",
+		"name: blog\ncontainers: {web: {scale: 1}}":                                      "Image",
+		"name: blog\ncontainers: {a: {image: x, links: [b]}, b: {image: x, links: [a]}}": "circular",
+	}
+	for doc, msg := range expects {
+		app, err := ParseAppFile([]byte(doc))
+		if err == nil {
+			_, err = app.Manifests()
+		}
+		if err == nil || !strings.Contains(err.Error(), msg) {
+			t.Error(doc, err)
+		}
+	}
+}
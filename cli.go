@@ -0,0 +1,443 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+const usage = `usage: dokkaa-conductor [command] [args]
+
+Runs the conductor daemon when no command is given.
+
+commands:
+  apply -f FILE                  set manifests of the app described in FILE ("-" for stdin)
+  delete APP[/CONTAINER]         delete manifests of the app or the container
+  scale APP/CONTAINER N          change the scale of the container
+  ps [APP]                       list containers and their running replicas
+  hosts                          list hosts and their resources
+  cordon HOST                    stop the host acquiring new slots
+  uncordon HOST                  let the host acquire new slots again
+  drain HOST                     release all slots of the host to other hosts and remove its containers
+  status APP[/CONTAINER]         show status, replicas, revisions and services
+  secret set APP NAME VALUE      store an encrypted secret ("-" reads VALUE from stdin)
+  secret delete APP NAME         delete a secret`
+
+// client implements the commands operating the cluster through etcd.
+type client struct {
+	etcdClient EtcdInterface
+	in         io.Reader
+	out        io.Writer
+}
+
+func NewClient(cli EtcdInterface) *client {
+	return &client{
+		etcdClient: cli,
+		in:         os.Stdin,
+		out:        os.Stdout,
+	}
+}
+
+func (c *client) Run(args []string) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "apply":
+		if len(args) != 2 || args[0] != "-f" {
+			break
+		}
+		return c.apply(args[1])
+	case "delete":
+		if len(args) != 1 {
+			break
+		}
+		return c.delete(args[0])
+	case "scale":
+		if len(args) != 2 {
+			break
+		}
+		return c.scale(args[0], args[1])
+	case "ps":
+		if len(args) > 1 {
+			break
+		}
+		return c.ps(strings.Join(args, ""))
+	case "hosts":
+		if len(args) != 0 {
+			break
+		}
+		return c.hosts()
+	case "cordon", "uncordon", "drain":
+		if len(args) != 1 {
+			break
+		}
+		return c.setMode(cmd, args[0])
+	case "status":
+		if len(args) != 1 {
+			break
+		}
+		return c.status(args[0])
+	case "secret":
+		switch {
+		case len(args) == 4 && args[0] == "set":
+			return c.setSecret(args[1], args[2], args[3])
+		case len(args) == 3 && args[0] == "delete":
+			return c.deleteSecret(args[1], args[2])
+		}
+	default:
+		return fmt.Errorf("unknown command %s\n%s", cmd, usage)
+	}
+	return fmt.Errorf("invalid arguments for %s\n%s", cmd, usage)
+}
+
+// splitRef splits "<app>/<container>" into its app and container names.
+func splitRef(ref string) (app, container string) {
+	parts := strings.SplitN(ref, "/", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+func (c *client) readInput(name string) ([]byte, error) {
+	if name == "-" {
+		return ioutil.ReadAll(c.in)
+	}
+	return ioutil.ReadFile(name)
+}
+
+func (c *client) apply(file string) error {
+	data, err := c.readInput(file)
+	if err != nil {
+		return err
+	}
+	app, err := ParseAppFile(data)
+	if err != nil {
+		return err
+	}
+	err = app.Apply(c.etcdClient)
+	if err != nil {
+		return err
+	}
+	names := []string{}
+	for name := range app.Containers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(c.out, "%s/%s applied\n", app.Name, name)
+	}
+	return nil
+}
+
+// delete deletes manifests only. Conductors release the slots and remove
+// the containers when they see the deletion.
+func (c *client) delete(ref string) error {
+	app, container := splitRef(ref)
+	manifests, err := c.manifests(app)
+	if err != nil {
+		return err
+	}
+	deleted := false
+	for _, m := range manifests {
+		if container != "" && m.ContainerName != container {
+			continue
+		}
+		_, err = c.etcdClient.Delete(m.ManifestKey(), false)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.out, "%s/%s deleted\n", m.AppName, m.ContainerName)
+		deleted = true
+	}
+	if !deleted {
+		return fmt.Errorf("%s not found", ref)
+	}
+	return nil
+}
+
+// scale rewrites Scale of the manifest keeping the rest of its value as is.
+func (c *client) scale(ref, n string) error {
+	app, container := splitRef(ref)
+	if container == "" {
+		return errors.New("scale needs APP/CONTAINER")
+	}
+	scale, err := strconv.Atoi(n)
+	if err != nil || scale < 1 {
+		return fmt.Errorf("invalid scale %s: must be at least 1", n)
+	}
+	m := &Manifest{AppName: app, ContainerName: container}
+	resp, err := c.etcdClient.Get(m.ManifestKey(), false, false)
+	if err != nil {
+		return err
+	}
+	var fields map[string]interface{}
+	err = json.Unmarshal([]byte(resp.Node.Value), &fields)
+	if err != nil {
+		return err
+	}
+	for k := range fields {
+		if strings.EqualFold(k, "scale") {
+			delete(fields, k)
+		}
+	}
+	fields["Scale"] = scale
+	value, _ := json.Marshal(fields)
+	_, err = c.etcdClient.CompareAndSwap(m.ManifestKey(), string(value), 0, "", resp.Node.ModifiedIndex)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "%s scaled to %d\n", ref, scale)
+	return nil
+}
+
+func (c *client) ps(app string) error {
+	manifests, err := c.manifests(app)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(c.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "APP\tCONTAINER\tIMAGE\tREVISION\tRUNNING\tHOSTS")
+	for _, m := range manifests {
+		hosts, _ := c.hostEntries(m)
+		running := 0
+		addrs := []string{}
+		for _, h := range hosts {
+			if h.Status == hostStatusRunning {
+				running++
+			}
+			addrs = append(addrs, h.Addr)
+		}
+		image, scale := "-", "-"
+		if m.Container != nil {
+			image, scale = m.Container.Image, strconv.Itoa(m.Container.Scale)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d/%s\t%s\n",
+			m.AppName, m.ContainerName, image, m.Revision,
+			running, scale, strings.Join(addrs, ","))
+	}
+	return w.Flush()
+}
+
+func (c *client) hosts() error {
+	resp, err := c.etcdClient.Get("/hosts", true, true)
+	if err != nil && !isKeyNotFound(err) {
+		return err
+	}
+	w := tabwriter.NewWriter(c.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tALIVE\tMODE\tLABELS\tCPU\tMEMORY\tCONTAINERS")
+	if resp != nil {
+		for _, n := range resp.Node.Nodes {
+			alive := false
+			mode := "-"
+			containers := 0
+			var labels map[string]string
+			var capacity, allocated Resources
+			for _, nn := range n.Nodes {
+				switch path.Base(nn.Key) {
+				case "alive":
+					alive = true
+				case "mode":
+					mode = nn.Value
+				case "labels":
+					json.Unmarshal([]byte(nn.Value), &labels)
+				case "capacity":
+					json.Unmarshal([]byte(nn.Value), &capacity)
+				case "allocated":
+					json.Unmarshal([]byte(nn.Value), &allocated)
+				case "containers":
+					containers = len(nn.Nodes)
+				}
+			}
+			fmt.Fprintf(w, "%s\t%t\t%s\t%s\t%g/%g\t%d/%d\t%d\n",
+				path.Base(n.Key), alive, mode, formatLabels(labels),
+				allocated.CPU, capacity.CPU, allocated.Memory, capacity.Memory, containers)
+		}
+	}
+	return w.Flush()
+}
+
+// setMode cordons, uncordons or drains the host. Conductors read the mode
+// of their host, so the host is drained by its own conductor.
+func (c *client) setMode(cmd, ip string) error {
+	mode := map[string]string{
+		"cordon":   hostModeCordon,
+		"uncordon": "",
+		"drain":    hostModeDrain,
+	}[cmd]
+	err := setHostMode(c.etcdClient, ip, mode)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "%s %sed\n", ip, cmd)
+	return nil
+}
+
+func formatLabels(labels map[string]string) string {
+	pairs := []string{}
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (c *client) status(ref string) error {
+	app, container := splitRef(ref)
+	manifests, err := c.manifests(app)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, m := range manifests {
+		if container != "" && m.ContainerName != container {
+			continue
+		}
+		found = true
+		c.containerStatus(m)
+		fmt.Fprintln(c.out)
+	}
+	if !found {
+		return fmt.Errorf("%s not found", ref)
+	}
+	return c.services(app)
+}
+
+func (c *client) containerStatus(m *Manifest) {
+	fmt.Fprintf(c.out, "%s/%s\n", m.AppName, m.ContainerName)
+	var status ManifestStatus
+	resp, err := c.etcdClient.Get(m.StatusKey(), false, false)
+	if err == nil {
+		json.Unmarshal([]byte(resp.Node.Value), &status)
+	}
+	fmt.Fprintf(c.out, "manifest: %s %s\n", status.Status, status.Revision)
+	for _, e := range status.Errors {
+		fmt.Fprintf(c.out, "  %s\n", e)
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tSTATUS\tREVISION\tRESTARTS\tERROR")
+	hosts, _ := c.hostEntries(m)
+	for _, h := range hosts {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", h.Addr, h.Status, h.Revision, h.Restarts, h.Error)
+	}
+	w.Flush()
+
+	fmt.Fprintln(w, "REVISION\tSTATUS")
+	s := scheduler{etcdClient: c.etcdClient}
+	nodes, _ := s.listRevisions(m)
+	for _, n := range nodes {
+		var rev Revision
+		json.Unmarshal([]byte(n.Value), &rev)
+		current := ""
+		if path.Base(n.Key) == m.Revision {
+			current = " (current)"
+		}
+		fmt.Fprintf(w, "%s\t%s%s\n", path.Base(n.Key), rev.Status, current)
+	}
+	w.Flush()
+}
+
+// services prints services of the app announced to skydns.
+func (c *client) services(app string) error {
+	s := &service{App: app}
+	resp, err := c.etcdClient.Get(s.appPath(), true, true)
+	if err != nil {
+		if isKeyNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	w := tabwriter.NewWriter(c.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVICE\tHOST\tPORT")
+	for _, n := range resp.Node.Nodes {
+		var ann Announcement
+		json.Unmarshal([]byte(n.Value), &ann)
+		fmt.Fprintf(w, "%s\t%s\t%d\n", path.Base(n.Key), ann.Host, ann.Port)
+	}
+	return w.Flush()
+}
+
+func (c *client) setSecret(app, name, value string) error {
+	if value == "-" {
+		data, err := ioutil.ReadAll(c.in)
+		if err != nil {
+			return err
+		}
+		value = strings.TrimSuffix(string(data), "\n")
+	}
+	key, err := parseClusterKey(getopt("CLUSTER_KEY", ""))
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		return errors.New("CLUSTER_KEY must be set to encrypt secrets")
+	}
+	return NewSecretStore(c.etcdClient, key).Set(app, name, value)
+}
+
+func (c *client) deleteSecret(app, name string) error {
+	return NewSecretStore(c.etcdClient, nil).Delete(app, name)
+}
+
+// manifests returns manifests of the app, or of all apps if app is empty,
+// sorted by their keys. Manifests which fail to parse are returned as well,
+// without Container if their value isn't JSON, so that they can still be
+// inspected and deleted.
+func (c *client) manifests(app string) ([]*Manifest, error) {
+	key := "/apps"
+	if app != "" {
+		key = path.Join(key, app)
+	}
+	resp, err := c.etcdClient.Get(key, true, true)
+	if err != nil {
+		if isKeyNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	nodes := etcd.Nodes{resp.Node}
+	if app == "" {
+		nodes = resp.Node.Nodes
+	}
+	var manifests []*Manifest
+	for _, a := range nodes {
+		for _, cn := range a.Nodes {
+			for _, n := range cn.Nodes {
+				appName, containerName, file, _ := keySubMatch(n.Key)
+				if file != "manifest" {
+					continue
+				}
+				m, _ := NewManifest(appName, containerName, n.Value)
+				manifests = append(manifests, m)
+			}
+		}
+	}
+	return manifests, nil
+}
+
+func (c *client) hostEntries(m *Manifest) ([]Host, error) {
+	resp, err := c.etcdClient.Get(m.HostsDirKey(), true, true)
+	if err != nil {
+		return nil, err
+	}
+	var hosts []Host
+	for _, n := range resp.Node.Nodes {
+		var h Host
+		json.Unmarshal([]byte(n.Value), &h)
+		hosts = append(hosts, h)
+	}
+	return hosts, nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitRef(t *testing.T) {
+	app, container := splitRef("blog/web")
+	if app != "blog" || container != "web" {
+		t.Error(app, container)
+	}
+	app, container = splitRef("blog")
+	if app != "blog" || container != "" {
+		t.Error(app, container)
+	}
+}
+
+func TestFormatLabels(t *testing.T) {
+	s := formatLabels(map[string]string{"zone": "a", "disk": "ssd"})
+	if s != "disk=ssd,zone=a" {
+		t.Error(s)
+	}
+}
+
+func TestClientRunUsage(t *testing.T) {
+	c := NewClient(&etcdMock{})
+	expects := map[string]string{
+		"":                   "usage",
+		"foo":                "unknown command foo",
+		"apply app.yaml":     "invalid arguments for apply",
+		"scale blog/web":     "invalid arguments for scale",
+		"scale blog 3":       "APP/CONTAINER",
+		"scale blog/web x":   "invalid scale",
+		"scale blog/web 0":   "invalid scale",
+		"secret get blog db": "invalid arguments for secret",
+		"cordon":             "invalid arguments for cordon",
+	}
+	for args, msg := range expects {
+		err := c.Run(strings.Fields(args))
+		if err == nil || !strings.Contains(err.Error(), msg) {
+			t.Error(args, err)
+		}
+	}
+}
+
+func TestClientScale(t *testing.T) {
+	e := newFakeEtcd()
+	e.Set("/apps/blog/web/manifest", `{"Image": "nginx", "scale": 1}`, 0)
+	out := &bytes.Buffer{}
+	c := NewClient(e)
+	c.out = out
+
+	err := c.Run([]string{"scale", "blog/web", "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	json.Unmarshal([]byte(e.value("/apps/blog/web/manifest")), &fields)
+	if fields["Scale"] != float64(3) || fields["scale"] != nil || fields["Image"] != "nginx" {
+		t.Error(fields)
+	}
+	if out.String() != "blog/web scaled to 3\n" {
+		t.Error(out.String())
+	}
+
+	err = c.Run([]string{"scale", "blog/web", "0"})
+	if err == nil {
+		t.Error("scale 0 must be rejected")
+	}
+	m, _ := NewManifest("blog", "web", e.value("/apps/blog/web/manifest"))
+	if m.Container.Scale != 3 {
+		t.Error(m.Container.Scale)
+	}
+
+	err = c.Run([]string{"scale", "blog/db", "2"})
+	if !isKeyNotFound(err) {
+		t.Error(err)
+	}
+}
+
+func TestClientScaleDeployed(t *testing.T) {
+	defer func(ip string) { hostIP = ip }(hostIP)
+	hostIP = "10.0.0.1"
+
+	e := newFakeEtcd()
+	e.Set("/hosts/10.0.0.1/alive", "", hostLeaseTTL)
+	e.Set("/hosts/10.0.0.2/alive", "", hostLeaseTTL)
+	e.Set("/apps/blog/web/manifest", `{"Image": "nginx", "Scale": 2}`, 0)
+	e.CreateInOrder("/apps/blog/web/hosts", `{"Addr": "10.0.0.2", "Status": "running"}`, hostLeaseTTL)
+	e.CreateInOrder("/apps/blog/web/hosts", hostEntry(hostStatusRunning), hostLeaseTTL)
+	d := newFakeDocker(runningContainer("blog---web"))
+	s := NewScheduler(d, e).(*scheduler)
+	c := NewClient(e)
+	c.out = &bytes.Buffer{}
+	e.record()
+
+	// this host holds the slot beyond the new scale
+	err := c.Run([]string{"scale", "blog/web", "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.onManifestChanged("blog", "web", e.lastEvent())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.InspectContainer("blog---web"); err == nil {
+		t.Error("scaled down container must be removed")
+	}
+	hosts, _ := s.getHosts(&Manifest{AppName: "blog", ContainerName: "web"})
+	if !reflect.DeepEqual(hosts, []string{"10.0.0.2"}) {
+		t.Error(hosts)
+	}
+
+	err = c.Run([]string{"scale", "blog/web", "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.onManifestChanged("blog", "web", e.lastEvent())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, err := d.InspectContainer("blog---web"); err != nil || !c.State.Running {
+		t.Error("scaled up container must be running", err)
+	}
+	hosts, _ = s.getHosts(&Manifest{AppName: "blog", ContainerName: "web"})
+	if !reflect.DeepEqual(hosts, []string{"10.0.0.2", "10.0.0.1"}) {
+		t.Error(hosts)
+	}
+}
+
+func TestClientRejectedManifest(t *testing.T) {
+	e := newFakeEtcd()
+	e.Set("/apps/blog/web/manifest", `{"Image": "nginx", "Env": {"DOKKAA_X": "1"}}`, 0)
+	e.Set("/apps/blog/web/status", `{"status": "rejected", "errors": ["Env: env DOKKAA_X conflicts with a variable generated by dokkaa"]}`, 0)
+	e.Set("/apps/blog/db/manifest", `{"Image": `, 0)
+	out := &bytes.Buffer{}
+	c := NewClient(e)
+	c.out = out
+
+	err := c.Run([]string{"ps", "blog"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "nginx") || !strings.Contains(out.String(), "db") {
+		t.Error(out.String())
+	}
+
+	out.Reset()
+	err = c.Run([]string{"status", "blog/web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "manifest: rejected") || !strings.Contains(out.String(), "DOKKAA_X") {
+		t.Error(out.String())
+	}
+
+	err = c.Run([]string{"delete", "blog"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.value("/apps/blog/web/manifest") != "" || e.value("/apps/blog/db/manifest") != "" {
+		t.Error("manifests must be deleted")
+	}
+}
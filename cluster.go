@@ -1,8 +1,8 @@
 package main
 
 import (
-	"log"
 	"net/url"
+	"path"
 	"regexp"
 	"strings"
 
@@ -11,6 +11,8 @@ import (
 
 type Cluster interface {
 	GetClusterIPs() []string
+	GetHosts() []string
+	HostLoads() (map[string]int, error)
 	HostLoadOrder(ip string) (int, error)
 }
 
@@ -44,6 +46,7 @@ func (c cluster) HostLoadOrder(ip string) (int, error) {
 		return 0, nil
 	}
 
+	modes := c.hostModes()
 	order := 0
 	thisHostCnt := hostRanks[hostIP]
 	ips := c.GetClusterIPs()
@@ -51,6 +54,14 @@ func (c cluster) HostLoadOrder(ip string) (int, error) {
 		if ip == hostIP {
 			continue
 		}
+		if modes[hostIP] != "" && modes[ip] == "" {
+			// unschedulable hosts come after schedulable ones
+			order++
+			continue
+		}
+		if modes[ip] != "" {
+			continue
+		}
 		n, ok := hostRanks[ip]
 		if !ok {
 			n = 0
@@ -59,8 +70,7 @@ func (c cluster) HostLoadOrder(ip string) (int, error) {
 			order++
 		}
 	}
-	log.Printf("ranks: %d", hostRanks)
-	log.Printf("order: %d", order)
+	logger.Op("rank").Debug("host load order", "ranks", hostRanks, "order", order)
 	return order, nil
 }
 
@@ -83,8 +93,59 @@ func (c cluster) getHostRanks() (map[string]int, error) {
 				break
 			}
 		}
+		if containersNode == nil {
+			hostRanks[host] = 0
+			continue
+		}
 		hostRanks[host] = len(containersNode.Nodes)
 	}
 
 	return hostRanks, nil
 }
+
+// hostModes returns modes of hosts which are cordoned or drained.
+func (c cluster) hostModes() map[string]string {
+	modes := map[string]string{}
+	resp, err := c.etcd.Get("/hosts", false, true)
+	if err != nil {
+		return modes
+	}
+	for _, node := range resp.Node.Nodes {
+		for _, nn := range node.Nodes {
+			if nn.Key == node.Key+"/mode" {
+				modes[path.Base(node.Key)] = nn.Value
+			}
+		}
+	}
+	return modes
+}
+
+// GetHosts returns IPs of hosts whose conductor is alive.
+func (c cluster) GetHosts() []string {
+	ips := []string{}
+	resp, err := c.etcd.Get("/hosts", true, true)
+	if err != nil {
+		return ips
+	}
+	for _, node := range resp.Node.Nodes {
+		for _, nn := range node.Nodes {
+			if nn.Key == node.Key+"/alive" {
+				ips = append(ips, path.Base(node.Key))
+				break
+			}
+		}
+	}
+	return ips
+}
+
+// HostLoads returns the number of containers running on each host.
+func (c cluster) HostLoads() (map[string]int, error) {
+	loads, err := c.getHostRanks()
+	if err != nil {
+		if isKeyNotFound(err) {
+			return map[string]int{}, nil
+		}
+		return nil, err
+	}
+	return loads, nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"path"
+	"strings"
+)
+
+var (
+	// hostLabels are published under /hosts/<ip>/labels and matched
+	// against constraints of manifests.
+	hostLabels = map[string]string{}
+)
+
+// parseLabels parses labels given as "key=value,key=value".
+func parseLabels(s string) map[string]string {
+	labels := map[string]string{}
+	for _, kv := range strings.Split(s, ",") {
+		parts := strings.SplitN(strings.TrimSpace(kv), "=", 2)
+		if parts[0] == "" {
+			continue
+		}
+		if len(parts) == 1 {
+			labels[parts[0]] = ""
+		} else {
+			labels[parts[0]] = parts[1]
+		}
+	}
+	return labels
+}
+
+func (c Constraint) match(labels map[string]string) bool {
+	v, ok := labels[c.Label]
+	switch c.Op {
+	case "", constraintEquals:
+		return ok && v == c.Value
+	case constraintIn:
+		return ok && containsString(c.Values, v)
+	case constraintNotIn:
+		return !ok || !containsString(c.Values, v)
+	}
+	return false
+}
+
+// refMatches reports whether ref ("<app>" or "<app>/<container>") refers
+// to the container.
+func refMatches(ref, app, container string) bool {
+	parts := strings.SplitN(ref, "/", 2)
+	if len(parts) == 1 {
+		return parts[0] == app
+	}
+	return parts[0] == app && parts[1] == container
+}
+
+// clusterState is a snapshot of hosts and placed containers used to decide
+// which hosts can run a manifest.
+type clusterState struct {
+	labels   map[string]map[string]string
+	capacity map[string]Resources
+	// placements maps a host IP to "<app>/<container>" placed on it
+	placements map[string][]string
+	// requests maps "<app>/<container>" to resources requested by a replica
+	requests map[string]Resources
+	// ports maps "<app>/<container>" to its fixed host ports
+	ports map[string][]Port
+	// modes maps a host IP to its mode if the host is cordoned or drained
+	modes map[string]string
+}
+
+func (s scheduler) clusterState() (*clusterState, error) {
+	cs := &clusterState{
+		labels:     map[string]map[string]string{},
+		capacity:   map[string]Resources{},
+		placements: map[string][]string{},
+		requests:   map[string]Resources{},
+		ports:      map[string][]Port{},
+		modes:      map[string]string{},
+	}
+
+	resp, err := s.etcdClient.Get("/hosts", false, true)
+	if err != nil && !isKeyNotFound(err) {
+		return nil, err
+	}
+	if err == nil {
+		for _, node := range resp.Node.Nodes {
+			ip := path.Base(node.Key)
+			for _, nn := range node.Nodes {
+				switch nn.Key {
+				case node.Key + "/labels":
+					labels := map[string]string{}
+					json.Unmarshal([]byte(nn.Value), &labels)
+					cs.labels[ip] = labels
+				case node.Key + "/capacity":
+					var capacity Resources
+					json.Unmarshal([]byte(nn.Value), &capacity)
+					cs.capacity[ip] = capacity
+				case node.Key + "/mode":
+					cs.modes[ip] = nn.Value
+				}
+			}
+		}
+	}
+
+	resp, err = s.etcdClient.Get("/apps", false, true)
+	if err != nil {
+		if isKeyNotFound(err) {
+			return cs, nil
+		}
+		return nil, err
+	}
+	for _, app := range resp.Node.Nodes {
+		for _, c := range app.Nodes {
+			ref := path.Base(app.Key) + "/" + path.Base(c.Key)
+			for _, n := range c.Nodes {
+				switch n.Key {
+				case c.Key + "/manifest":
+					var container Container
+					json.Unmarshal([]byte(n.Value), &container)
+					if container.Resources != nil {
+						cs.requests[ref] = *container.Resources
+					}
+					cs.ports[ref] = container.Ports
+				case c.Key + "/hosts":
+					for _, hn := range n.Nodes {
+						var h Host
+						json.Unmarshal([]byte(hn.Value), &h)
+						cs.placements[h.Addr] = append(cs.placements[h.Addr], ref)
+					}
+				}
+			}
+		}
+	}
+	return cs, nil
+}
+
+// allocated returns the resources requested by containers placed on the
+// host except the manifest itself.
+func (cs *clusterState) allocated(ip string, m *Manifest) Resources {
+	var r Resources
+	for _, p := range cs.placements[ip] {
+		if m != nil && p == m.AppName+"/"+m.ContainerName {
+			continue
+		}
+		r.CPU += cs.requests[p].CPU
+		r.Memory += cs.requests[p].Memory
+	}
+	return r
+}
+
+// fits reports whether the host has enough free capacity for the manifest.
+// Hosts which don't publish their capacity are assumed to fit.
+func (cs *clusterState) fits(m *Manifest, ip string) bool {
+	req := m.Container.Resources
+	capacity, ok := cs.capacity[ip]
+	if req == nil || !ok {
+		return true
+	}
+	allocated := cs.allocated(ip, m)
+	if capacity.CPU > 0 && allocated.CPU+req.CPU > capacity.CPU {
+		return false
+	}
+	if capacity.Memory > 0 && allocated.Memory+req.Memory > capacity.Memory {
+		return false
+	}
+	return true
+}
+
+// portsFree reports whether no other container placed on the host binds
+// the host ports of the manifest.
+func (cs *clusterState) portsFree(m *Manifest, ip string) bool {
+	for _, p := range cs.placements[ip] {
+		if p == m.AppName+"/"+m.ContainerName {
+			continue
+		}
+		for _, used := range cs.ports[p] {
+			for _, port := range m.Container.Ports {
+				if port.conflicts(used) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// schedulable reports whether the host may acquire new slots.
+func (cs *clusterState) schedulable(ip string) bool {
+	return cs.modes[ip] == ""
+}
+
+// eligible reports whether the host satisfies the constraints, affinity,
+// anti-affinity, resource requests and host ports of the manifest.
+func (cs *clusterState) eligible(m *Manifest, ip string) bool {
+	c := m.Container
+	if !cs.fits(m, ip) || !cs.portsFree(m, ip) {
+		return false
+	}
+	for _, constraint := range c.Constraints {
+		if !constraint.match(cs.labels[ip]) {
+			return false
+		}
+	}
+	for _, ref := range c.Affinity {
+		if !cs.placed(ip, ref, m) {
+			return false
+		}
+	}
+	for _, ref := range c.AntiAffinity {
+		if cs.placed(ip, ref, m) {
+			return false
+		}
+	}
+	return true
+}
+
+// placed reports whether a container referred by ref other than the
+// manifest itself is placed on the host.
+func (cs *clusterState) placed(ip, ref string, m *Manifest) bool {
+	for _, p := range cs.placements[ip] {
+		parts := strings.SplitN(p, "/", 2)
+		if parts[0] == m.AppName && parts[1] == m.ContainerName {
+			continue
+		}
+		if refMatches(ref, parts[0], parts[1]) {
+			return true
+		}
+	}
+	return false
+}
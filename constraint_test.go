@@ -0,0 +1,142 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLabels(t *testing.T) {
+	labels := parseLabels("disk=ssd, zone=a,gpu")
+	expected := map[string]string{"disk": "ssd", "zone": "a", "gpu": ""}
+	if !reflect.DeepEqual(labels, expected) {
+		t.Error(labels)
+	}
+	if len(parseLabels("")) != 0 {
+		t.Error("empty labels")
+	}
+}
+
+func TestConstraintMatch(t *testing.T) {
+	labels := map[string]string{"disk": "ssd", "zone": "a"}
+	expects := map[*Constraint]bool{
+		&Constraint{Label: "disk", Value: "ssd"}:                                 true,
+		&Constraint{Label: "disk", Op: constraintEquals, Value: "hdd"}:           false,
+		&Constraint{Label: "zone", Op: constraintIn, Values: []string{"a", "b"}}: true,
+		&Constraint{Label: "gpu", Op: constraintIn, Values: []string{"yes"}}:     false,
+		&Constraint{Label: "zone", Op: constraintNotIn, Values: []string{"a"}}:   false,
+		&Constraint{Label: "gpu", Op: constraintNotIn, Values: []string{"yes"}}:  true,
+		&Constraint{Label: "disk", Op: "unknown"}:                                false,
+	}
+	for c, expected := range expects {
+		if c.match(labels) != expected {
+			t.Error(c)
+		}
+	}
+}
+
+func TestEligible(t *testing.T) {
+	cs := &clusterState{
+		labels: map[string]map[string]string{
+			"10.0.0.1": {"disk": "ssd"},
+			"10.0.0.2": {"disk": "hdd"},
+		},
+		placements: map[string][]string{
+			"10.0.0.1": {"blog/db", "blog/web"},
+			"10.0.0.2": {"shop/web"},
+		},
+	}
+
+	m, _ := NewManifest("blog", "web", `{"Constraints": [{"Label": "disk", "Value": "ssd"}]}`)
+	if !cs.eligible(m, "10.0.0.1") || cs.eligible(m, "10.0.0.2") {
+		t.Error("constraints")
+	}
+
+	m, _ = NewManifest("blog", "web", `{"Affinity": ["blog/db"]}`)
+	if !cs.eligible(m, "10.0.0.1") || cs.eligible(m, "10.0.0.2") {
+		t.Error("affinity")
+	}
+
+	m, _ = NewManifest("blog", "web", `{"AntiAffinity": ["shop"]}`)
+	if !cs.eligible(m, "10.0.0.1") || cs.eligible(m, "10.0.0.2") {
+		t.Error("anti-affinity")
+	}
+
+	// the manifest itself doesn't count
+	m, _ = NewManifest("blog", "web", `{"AntiAffinity": ["blog/web"]}`)
+	if !cs.eligible(m, "10.0.0.1") {
+		t.Error("anti-affinity to itself")
+	}
+	m, _ = NewManifest("blog", "cache", `{"AntiAffinity": ["blog"]}`)
+	if cs.eligible(m, "10.0.0.1") || !cs.eligible(m, "10.0.0.2") {
+		t.Error("anti-affinity to the app")
+	}
+}
+
+func TestEligibleResources(t *testing.T) {
+	cs := &clusterState{
+		capacity: map[string]Resources{
+			"10.0.0.1": {CPU: 2, Memory: 1024},
+			"10.0.0.2": {CPU: 4, Memory: 4096},
+		},
+		placements: map[string][]string{
+			"10.0.0.1": {"blog/db", "blog/web"},
+		},
+		requests: map[string]Resources{
+			"blog/db":  {CPU: 1, Memory: 512},
+			"blog/web": {CPU: 0.5, Memory: 256},
+		},
+	}
+
+	m, _ := NewManifest("shop", "web", `{"Resources": {"cpu": 1, "memory": 256}}`)
+	if cs.eligible(m, "10.0.0.1") || !cs.eligible(m, "10.0.0.2") {
+		t.Error("cpu")
+	}
+	m, _ = NewManifest("shop", "web", `{"Resources": {"cpu": 0.5, "memory": 512}}`)
+	if cs.eligible(m, "10.0.0.1") || !cs.eligible(m, "10.0.0.2") {
+		t.Error("memory")
+	}
+	// resources of the manifest itself are not counted twice
+	m, _ = NewManifest("blog", "web", `{"Resources": {"cpu": 1, "memory": 512}}`)
+	if !cs.eligible(m, "10.0.0.1") {
+		t.Error("own allocation")
+	}
+	// hosts without capacity are assumed to fit
+	if !cs.eligible(m, "10.0.0.3") {
+		t.Error("unknown capacity")
+	}
+}
+
+func TestEligiblePorts(t *testing.T) {
+	cs := &clusterState{
+		placements: map[string][]string{
+			"10.0.0.1": {"blog/web"},
+			"10.0.0.2": {"dns/server"},
+		},
+		ports: map[string][]Port{
+			"blog/web":   {{HostPort: 80, ContainerPort: 8080}},
+			"dns/server": {{HostPort: 53, ContainerPort: 53, Protocol: "udp", HostIP: "10.0.0.2"}},
+		},
+	}
+
+	m, _ := NewManifest("shop", "web", `{"Ports": [{"HostPort": 80, "ContainerPort": 80}]}`)
+	if cs.eligible(m, "10.0.0.1") || !cs.eligible(m, "10.0.0.2") {
+		t.Error("tcp port")
+	}
+	m, _ = NewManifest("shop", "dns", `{"Ports": [{"HostPort": 53, "ContainerPort": 53}]}`)
+	if !cs.eligible(m, "10.0.0.2") {
+		t.Error("different protocols must not conflict")
+	}
+	m, _ = NewManifest("shop", "dns", `{"Ports": [{"HostPort": 53, "ContainerPort": 53, "Protocol": "udp", "HostIP": "127.0.0.1"}]}`)
+	if !cs.eligible(m, "10.0.0.2") {
+		t.Error("different host IPs must not conflict")
+	}
+	m, _ = NewManifest("shop", "dns", `{"Ports": [{"HostPort": 53, "ContainerPort": 53, "Protocol": "udp"}]}`)
+	if cs.eligible(m, "10.0.0.2") {
+		t.Error("udp port")
+	}
+	// the manifest itself doesn't conflict with its own ports
+	m, _ = NewManifest("blog", "web", `{"Ports": [{"HostPort": 80, "ContainerPort": 8080}]}`)
+	if !cs.eligible(m, "10.0.0.1") {
+		t.Error("own ports")
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/fsouza/go-dockerclient"
 )
@@ -14,9 +15,15 @@ type DockerInterface interface {
 	StartContainer(id string, hostConfig *docker.HostConfig) error
 	StopContainer(id string, timeout uint) error
 	RemoveContainer(opts docker.RemoveContainerOptions) error
+	RenameContainer(opts docker.RenameContainerOptions) error
 	WaitContainer(id string) (int, error)
 	PullImage(opts docker.PullImageOptions, auth docker.AuthConfiguration) error
 	AddEventListener(listener chan<- *docker.APIEvents) error
+	RemoveEventListener(listener chan *docker.APIEvents) error
+	CreateExec(opts docker.CreateExecOptions) (*docker.Exec, error)
+	StartExec(id string, opts docker.StartExecOptions) error
+	InspectExec(id string) (*docker.ExecInspect, error)
+	Info() (*docker.DockerInfo, error)
 }
 
 func NewDockerClient(host string) (DockerInterface, error) {
@@ -56,7 +63,10 @@ func (dp dockerPuller) Pull(image string) error {
 		Tag:        tag,
 	}
 
-	return dp.client.PullImage(opts, docker.AuthConfiguration{})
+	defer imagePullDuration.ObserveSince(time.Now())
+	err := dp.client.PullImage(opts, docker.AuthConfiguration{})
+	imagePullsTotal.Inc(resultOf(err))
+	return err
 }
 
 func parseImageName(image string) (string, string) {
@@ -100,8 +110,9 @@ func NewDockerRunner(client DockerInterface) DockerRunner {
 
 func (r dockerRunner) Run(image string, opts DockerRunOptions) (DockerContainerID, error) {
 	createOpts := docker.CreateContainerOptions{
-		Name:   opts.ContainerName,
-		Config: opts.ContainerConfig,
+		Name:       opts.ContainerName,
+		Config:     opts.ContainerConfig,
+		HostConfig: opts.HostConfig,
 	}
 	createOpts.Config.Image = image
 
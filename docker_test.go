@@ -2,6 +2,8 @@ package main
 
 import (
 	"errors"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/dchest/uniuri"
@@ -47,6 +49,10 @@ func (d *dockerMock) RemoveContainer(opts docker.RemoveContainerOptions) error {
 	panic("")
 }
 
+func (d *dockerMock) RenameContainer(opts docker.RenameContainerOptions) error {
+	panic("")
+}
+
 func (d *dockerMock) WaitContainer(id string) (int, error) {
 	panic("")
 }
@@ -60,6 +66,168 @@ func (d *dockerMock) AddEventListener(listener chan<- *docker.APIEvents) error {
 	panic("")
 }
 
+func (d *dockerMock) RemoveEventListener(listener chan *docker.APIEvents) error {
+	panic("")
+}
+
+func (d *dockerMock) CreateExec(opts docker.CreateExecOptions) (*docker.Exec, error) {
+	panic("")
+}
+
+func (d *dockerMock) StartExec(id string, opts docker.StartExecOptions) error {
+	panic("")
+}
+
+func (d *dockerMock) InspectExec(id string) (*docker.ExecInspect, error) {
+	panic("")
+}
+
+func (d *dockerMock) Info() (*docker.DockerInfo, error) {
+	panic("")
+}
+
+// fakeDocker keeps containers by name and records removed containers.
+type fakeDocker struct {
+	dockerMock
+	mu      sync.Mutex
+	byName  map[string]*docker.Container
+	removed []string
+}
+
+func newFakeDocker(containers ...*docker.Container) *fakeDocker {
+	d := &fakeDocker{byName: map[string]*docker.Container{}}
+	for _, c := range containers {
+		d.byName[strings.TrimPrefix(c.Name, "/")] = c
+	}
+	return d
+}
+
+// runningContainer returns a running container named name with env.
+func runningContainer(name string, env ...string) *docker.Container {
+	return &docker.Container{
+		ID:     name + "-id",
+		Name:   "/" + name,
+		Config: &docker.Config{Env: env},
+		State:  docker.State{Running: true},
+	}
+}
+
+func (d *fakeDocker) find(id string) (string, *docker.Container) {
+	for name, c := range d.byName {
+		if name == id || c.ID == id {
+			return name, c
+		}
+	}
+	return "", nil
+}
+
+func (d *fakeDocker) ListContainers(options docker.ListContainersOptions) ([]docker.APIContainers, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var containers []docker.APIContainers
+	for name, c := range d.byName {
+		status := "Exited (0) 1 minute ago"
+		if c.State.Running {
+			status = "Up 1 minute"
+		}
+		containers = append(containers, docker.APIContainers{
+			ID:     c.ID,
+			Names:  []string{"/" + name},
+			Status: status,
+		})
+	}
+	return containers, nil
+}
+
+func (d *fakeDocker) InspectContainer(id string) (*docker.Container, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, c := d.find(id)
+	if c == nil {
+		return nil, &docker.NoSuchContainer{ID: id}
+	}
+	copied := *c
+	return &copied, nil
+}
+
+func (d *fakeDocker) CreateContainer(opts docker.CreateContainerOptions) (*docker.Container, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, c := d.find(opts.Name); c != nil {
+		return nil, errors.New("conflict: " + opts.Name)
+	}
+	c := &docker.Container{
+		ID:              opts.Name + "-" + uniuri.New(),
+		Name:            "/" + opts.Name,
+		Config:          opts.Config,
+		NetworkSettings: &docker.NetworkSettings{},
+	}
+	d.byName[opts.Name] = c
+	return c, nil
+}
+
+func (d *fakeDocker) StartContainer(id string, hostConfig *docker.HostConfig) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, c := d.find(id)
+	if c == nil {
+		return &docker.NoSuchContainer{ID: id}
+	}
+	c.State.Running = true
+	return nil
+}
+
+func (d *fakeDocker) StopContainer(id string, timeout uint) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, c := d.find(id)
+	if c == nil {
+		return &docker.NoSuchContainer{ID: id}
+	}
+	c.State.Running = false
+	return nil
+}
+
+func (d *fakeDocker) WaitContainer(id string) (int, error) {
+	return 0, nil
+}
+
+func (d *fakeDocker) RemoveContainer(opts docker.RemoveContainerOptions) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	name, c := d.find(opts.ID)
+	if c == nil {
+		return &docker.NoSuchContainer{ID: opts.ID}
+	}
+	if c.State.Running && !opts.Force {
+		return errors.New("container is running: " + name)
+	}
+	delete(d.byName, name)
+	d.removed = append(d.removed, name)
+	return nil
+}
+
+func (d *fakeDocker) RenameContainer(opts docker.RenameContainerOptions) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	name, c := d.find(opts.ID)
+	if c == nil {
+		return &docker.NoSuchContainer{ID: opts.ID}
+	}
+	delete(d.byName, name)
+	c.Name = "/" + opts.Name
+	d.byName[opts.Name] = c
+	return nil
+}
+
+func (d *fakeDocker) AddEventListener(listener chan<- *docker.APIEvents) error {
+	return nil
+}
+
+func (d *fakeDocker) RemoveEventListener(listener chan *docker.APIEvents) error {
+	return nil
+}
+
 func TestRun(t *testing.T) {
 	runner := NewDockerRunner(&dockerMock{})
 	options := DockerRunOptions{
@@ -1,23 +1,41 @@
 package main
 
 import (
-	"log"
-
 	"github.com/coreos/go-etcd/etcd"
 )
 
 type EtcdInterface interface {
+	CompareAndSwap(key string, value string, ttl uint64, prevValue string, prevIndex uint64) (*etcd.Response, error)
+	Create(key string, value string, ttl uint64) (*etcd.Response, error)
 	CreateInOrder(dir string, value string, ttl uint64) (*etcd.Response, error)
 	Delete(key string, recursive bool) (*etcd.Response, error)
 	Get(key string, sort, recursive bool) (*etcd.Response, error)
 	GetCluster() []string
 	Set(key string, value string, ttl uint64) (*etcd.Response, error)
 	SyncCluster() bool
+	Update(key string, value string, ttl uint64) (*etcd.Response, error)
 	Watch(prefix string, waitIndex uint64, recursive bool, receiver chan *etcd.Response, stop chan bool) (*etcd.Response, error)
 }
 
+const (
+	etcdErrorKeyNotFound = 100
+	etcdErrorNodeExist   = 105
+)
+
+func isKeyNotFound(err error) bool {
+	e, ok := err.(*etcd.EtcdError)
+	return ok && e.ErrorCode == etcdErrorKeyNotFound
+}
+
+func isNodeExist(err error) bool {
+	e, ok := err.(*etcd.EtcdError)
+	return ok && e.ErrorCode == etcdErrorNodeExist
+}
+
 type EtcdWatcher interface {
-	Watch(prefix string, recursive bool) chan *etcd.Response
+	// Watch sends changes under prefix to the returned channel, which is
+	// closed once quit is closed.
+	Watch(prefix string, recursive bool, quit chan struct{}) chan *etcd.Response
 }
 
 type etcdWatcher struct {
@@ -30,32 +48,39 @@ func NewEtcdWatcher(cli EtcdInterface) EtcdWatcher {
 	}
 }
 
-func (w *etcdWatcher) Watch(prefix string, recursive bool) chan *etcd.Response {
+func (w *etcdWatcher) Watch(prefix string, recursive bool, quit chan struct{}) chan *etcd.Response {
 	wrapRecv := make(chan *etcd.Response)
 
 	go func() {
-	LOOP1:
+		defer close(wrapRecv)
 		for {
 			recv := make(chan *etcd.Response)
 			stop := make(chan bool)
 
 			go w.client.Watch(prefix, 0, recursive, recv, stop)
 
-		LOOP2:
+		LOOP:
 			for {
 				select {
-				case _, ok := <-wrapRecv:
-					if !ok {
-						break LOOP1
-					}
+				case <-quit:
+					close(stop)
+					return
 				case r, ok := <-recv:
 					if !ok {
-						log.Println("watching loop ended. reconnecting.")
+						logger.Op("watch").Warn("watching loop ended. reconnecting", "prefix", prefix)
+						etcdWatchReconnectsTotal.Inc(prefix)
 						close(stop)
-						break LOOP2
+						break LOOP
 					}
-					if r != nil {
-						wrapRecv <- r
+					if r == nil {
+						continue
+					}
+					etcdWatchEventsTotal.Inc(prefix)
+					select {
+					case wrapRecv <- r:
+					case <-quit:
+						close(stop)
+						return
 					}
 				}
 			}
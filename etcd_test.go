@@ -1,11 +1,29 @@
 package main
 
-import "github.com/coreos/go-etcd/etcd"
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+)
 
 type etcdMock struct {
 	watchChan chan *etcd.Response
 }
 
+func (e *etcdMock) CompareAndSwap(key string, value string, ttl uint64, prevValue string, prevIndex uint64) (*etcd.Response, error) {
+	panic("")
+}
+
+func (e *etcdMock) Create(key string, value string, ttl uint64) (*etcd.Response, error) {
+	panic("")
+}
+
 func (e *etcdMock) CreateInOrder(dir string, value string, ttl uint64) (*etcd.Response, error) {
 	panic("")
 }
@@ -30,6 +48,282 @@ func (e etcdMock) SyncCluster() bool {
 	panic("")
 }
 
-func (e etcdMock) Watch(prefix string, waitIndex uint64, recursive bool, receiver chan *etcd.Response, stop chan bool) (*etcd.Response, error) {
+func (e etcdMock) Update(key string, value string, ttl uint64) (*etcd.Response, error) {
 	panic("")
 }
+
+func (e etcdMock) Watch(prefix string, waitIndex uint64, recursive bool, receiver chan *etcd.Response, stop chan bool) (*etcd.Response, error) {
+	for {
+		select {
+		case <-stop:
+			return nil, nil
+		case r := <-e.watchChan:
+			select {
+			case receiver <- r:
+			case <-stop:
+				return nil, nil
+			}
+		}
+	}
+}
+
+// fakeEtcd is an in-memory etcd keeping values and indexes of keys. TTLs
+// are recorded but never expire. Changes made after record is called are
+// delivered to watchers.
+type fakeEtcd struct {
+	etcdMock
+	mu     sync.Mutex
+	index  uint64
+	nodes  map[string]*etcd.Node
+	events []*etcd.Response
+	// recording is set by record
+	recording bool
+}
+
+func newFakeEtcd() *fakeEtcd {
+	return &fakeEtcd{nodes: map[string]*etcd.Node{}}
+}
+
+func etcdError(code int, key string) error {
+	return &etcd.EtcdError{ErrorCode: code, Message: fmt.Sprint(code), Cause: key}
+}
+
+func (e *fakeEtcd) put(action, key, value string, ttl uint64) *etcd.Response {
+	key = path.Clean(key)
+	e.index++
+	prev := e.nodes[key]
+	n := &etcd.Node{
+		Key:           key,
+		Value:         value,
+		TTL:           int64(ttl),
+		CreatedIndex:  e.index,
+		ModifiedIndex: e.index,
+	}
+	if prev != nil {
+		n.CreatedIndex = prev.CreatedIndex
+	}
+	e.nodes[key] = n
+	copied := *n
+	resp := &etcd.Response{Action: action, Node: &copied, PrevNode: prev}
+	e.publish(resp)
+	return resp
+}
+
+func (e *fakeEtcd) publish(resp *etcd.Response) {
+	if e.recording {
+		e.events = append(e.events, resp)
+	}
+}
+
+// record starts recording changes for watchers.
+func (e *fakeEtcd) record() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.recording = true
+}
+
+// lastEvent returns the last recorded change.
+func (e *fakeEtcd) lastEvent() *etcd.Response {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.events) == 0 {
+		return nil
+	}
+	return e.events[len(e.events)-1]
+}
+
+// Watch sends the recorded changes under prefix to receiver until stop is
+// closed.
+func (e *fakeEtcd) Watch(prefix string, waitIndex uint64, recursive bool, receiver chan *etcd.Response, stop chan bool) (*etcd.Response, error) {
+	sent := 0
+	for {
+		e.mu.Lock()
+		events := e.events[sent:]
+		sent = len(e.events)
+		e.mu.Unlock()
+		for _, r := range events {
+			if !strings.HasPrefix(r.Node.Key, prefix) {
+				continue
+			}
+			select {
+			case receiver <- r:
+			case <-stop:
+				return nil, nil
+			}
+		}
+		select {
+		case <-stop:
+			return nil, nil
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func (e *fakeEtcd) CompareAndSwap(key string, value string, ttl uint64, prevValue string, prevIndex uint64) (*etcd.Response, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	n, ok := e.nodes[path.Clean(key)]
+	if !ok {
+		return nil, etcdError(etcdErrorKeyNotFound, key)
+	}
+	if (prevValue != "" && n.Value != prevValue) || (prevIndex != 0 && n.ModifiedIndex != prevIndex) {
+		return nil, etcdError(101, key)
+	}
+	return e.put("compareAndSwap", key, value, ttl), nil
+}
+
+func (e *fakeEtcd) Create(key string, value string, ttl uint64) (*etcd.Response, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.nodes[path.Clean(key)]; ok {
+		return nil, etcdError(etcdErrorNodeExist, key)
+	}
+	return e.put("create", key, value, ttl), nil
+}
+
+func (e *fakeEtcd) CreateInOrder(dir string, value string, ttl uint64) (*etcd.Response, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.put("create", fmt.Sprintf("%s/%020d", dir, e.index+1), value, ttl), nil
+}
+
+func (e *fakeEtcd) Delete(key string, recursive bool) (*etcd.Response, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	key = path.Clean(key)
+	prev, ok := e.nodes[key]
+	for k := range e.nodes {
+		if recursive && strings.HasPrefix(k, key+"/") {
+			delete(e.nodes, k)
+			ok = true
+		}
+	}
+	if !ok {
+		return nil, etcdError(etcdErrorKeyNotFound, key)
+	}
+	delete(e.nodes, key)
+	e.index++
+	resp := &etcd.Response{Action: "delete", Node: &etcd.Node{Key: key, ModifiedIndex: e.index}, PrevNode: prev}
+	e.publish(resp)
+	return resp, nil
+}
+
+func (e *fakeEtcd) Get(key string, sorted, recursive bool) (*etcd.Response, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	key = path.Clean(key)
+	if n, ok := e.nodes[key]; ok {
+		copied := *n
+		return &etcd.Response{Action: "get", Node: &copied}, nil
+	}
+	n := e.dir(key, recursive)
+	if n == nil {
+		return nil, etcdError(etcdErrorKeyNotFound, key)
+	}
+	return &etcd.Response{Action: "get", Node: n}, nil
+}
+
+// dir builds the directory node of key from the keys under it.
+func (e *fakeEtcd) dir(key string, recursive bool) *etcd.Node {
+	children := map[string]bool{}
+	prefix := strings.TrimSuffix(key, "/") + "/"
+	for k := range e.nodes {
+		if strings.HasPrefix(k, prefix) {
+			children[prefix+strings.SplitN(k[len(prefix):], "/", 2)[0]] = true
+		}
+	}
+	if len(children) == 0 {
+		return nil
+	}
+	keys := []string{}
+	for k := range children {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	d := &etcd.Node{Key: key, Dir: true}
+	for _, k := range keys {
+		if n, ok := e.nodes[k]; ok {
+			copied := *n
+			d.Nodes = append(d.Nodes, &copied)
+			continue
+		}
+		child := &etcd.Node{Key: k, Dir: true}
+		if recursive {
+			child = e.dir(k, true)
+		}
+		d.Nodes = append(d.Nodes, child)
+	}
+	return d
+}
+
+func (e *fakeEtcd) Set(key string, value string, ttl uint64) (*etcd.Response, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.put("set", key, value, ttl), nil
+}
+
+func (e *fakeEtcd) Update(key string, value string, ttl uint64) (*etcd.Response, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.nodes[path.Clean(key)]; !ok {
+		return nil, etcdError(etcdErrorKeyNotFound, key)
+	}
+	return e.put("update", key, value, ttl), nil
+}
+
+// value returns the value of key, or "" if it doesn't exist.
+func (e *fakeEtcd) value(key string) string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if n, ok := e.nodes[key]; ok {
+		return n.Value
+	}
+	return ""
+}
+
+func TestFakeEtcd(t *testing.T) {
+	e := newFakeEtcd()
+	e.Set("/apps/blog/web/manifest", "{}", 0)
+	e.CreateInOrder("/apps/blog/web/hosts", "a", 30)
+	e.CreateInOrder("/apps/blog/web/hosts", "b", 30)
+
+	resp, err := e.Get("/apps", true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	web := resp.Node.Nodes[0].Nodes[0]
+	if web.Key != "/apps/blog/web" || len(web.Nodes) != 2 || web.Nodes[1].Value != "{}" {
+		t.Fatal(web)
+	}
+	hosts := web.Nodes[0].Nodes
+	if len(hosts) != 2 || hosts[0].Value != "a" || hosts[1].Value != "b" {
+		t.Error(hosts)
+	}
+
+	_, err = e.Create("/apps/blog/web/manifest", "{}", 0)
+	if e, ok := err.(*etcd.EtcdError); !ok || e.ErrorCode != 105 {
+		t.Error(err)
+	}
+	_, err = e.Get("/apps/blog/db", false, false)
+	if !isKeyNotFound(err) {
+		t.Error(err)
+	}
+}
+
+func TestEtcdWatcherQuit(t *testing.T) {
+	e := &etcdMock{watchChan: make(chan *etcd.Response)}
+	quit := make(chan struct{})
+	recv := NewEtcdWatcher(e).Watch("/apps", true, quit)
+
+	e.watchChan <- &etcd.Response{Action: "set"}
+	r := <-recv
+	if r.Action != "set" {
+		t.Error(r)
+	}
+
+	close(quit)
+	_, ok := <-recv
+	if ok {
+		t.Error("channel must be closed after quit")
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// healthCheckOf returns the health check of the container declared in its manifest.
+func healthCheckOf(container *docker.Container) *HealthCheck {
+	value := containerEnv(container, "DOKKAA_HEALTHCHECK")
+	if value == "" {
+		return nil
+	}
+	var hc HealthCheck
+	err := json.Unmarshal([]byte(value), &hc)
+	if err != nil {
+		logForContainer(container.Name).Op("health").Warn("invalid health check", "err", err)
+		return nil
+	}
+	return &hc
+}
+
+func (hc *HealthCheck) timeout() time.Duration {
+	return time.Second * time.Duration(hc.Timeout)
+}
+
+func (hc *HealthCheck) interval() time.Duration {
+	return time.Second * time.Duration(hc.Interval)
+}
+
+// check runs the health check once against the container.
+func (hc *HealthCheck) check(dc DockerInterface, container *docker.Container) error {
+	switch hc.Type {
+	case healthCheckHTTP:
+		addr, err := hc.addr(container)
+		if err != nil {
+			return err
+		}
+		client := &http.Client{Timeout: hc.timeout()}
+		resp, err := client.Get("http://" + addr + hc.Path)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+			return fmt.Errorf("health check: %s returned %d", hc.Path, resp.StatusCode)
+		}
+		return nil
+	case healthCheckTCP:
+		addr, err := hc.addr(container)
+		if err != nil {
+			return err
+		}
+		conn, err := net.DialTimeout("tcp", addr, hc.timeout())
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case healthCheckExec:
+		return hc.exec(dc, container)
+	}
+	return errors.New("health check: unknown type " + hc.Type)
+}
+
+// addr returns the host address published for the checked port.
+func (hc *HealthCheck) addr(container *docker.Container) (string, error) {
+	port := docker.Port(strconv.Itoa(hc.Port) + "/tcp")
+	bindings := container.NetworkSettings.Ports[port]
+	if len(bindings) == 0 {
+		return "", errors.New("health check: no port binding for " + string(port))
+	}
+	return net.JoinHostPort(hostIP, bindings[0].HostPort), nil
+}
+
+func (hc *HealthCheck) exec(dc DockerInterface, container *docker.Container) error {
+	exec, err := dc.CreateExec(docker.CreateExecOptions{
+		Container: container.ID,
+		Cmd:       hc.Command,
+	})
+	if err != nil {
+		return err
+	}
+	err = dc.StartExec(exec.ID, docker.StartExecOptions{Detach: true})
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(hc.timeout())
+	for {
+		inspect, err := dc.InspectExec(exec.ID)
+		if err != nil {
+			return err
+		}
+		if !inspect.Running {
+			if inspect.ExitCode != 0 {
+				return fmt.Errorf("health check: command exited with code %d", inspect.ExitCode)
+			}
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New("health check: command timed out")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// runHealthCheck announces the container's services while its health
+// check passes and withdraws them once it fails Retries times in a row.
+func (r register) runHealthCheck(id DockerContainerID, hc *HealthCheck, stop chan struct{}) {
+	failures := 0
+	healthy := false
+	for {
+		container, err := r.dockerClient.InspectContainer(string(id))
+		if err != nil || !container.State.Running {
+			return
+		}
+		err = hc.check(r.dockerClient, container)
+		if err == nil {
+			failures = 0
+			if !healthy {
+				logForContainer(container.Name).Op("health").Info("container is healthy")
+			}
+			healthy = true
+			r.announce(container)
+		} else {
+			failures++
+			if healthy && failures >= hc.Retries {
+				logForContainer(container.Name).Op("health").Warn("container is unhealthy", "err", err)
+				healthy = false
+				r.withdraw(container)
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(hc.interval()):
+		}
+	}
+}
+
+type healthCheckers struct {
+	mu    sync.Mutex
+	stops map[DockerContainerID]chan struct{}
+}
+
+func newHealthCheckers() *healthCheckers {
+	return &healthCheckers{
+		stops: map[DockerContainerID]chan struct{}{},
+	}
+}
+
+func (hcs *healthCheckers) start(id DockerContainerID, f func(stop chan struct{})) {
+	hcs.mu.Lock()
+	defer hcs.mu.Unlock()
+	if _, ok := hcs.stops[id]; ok {
+		return
+	}
+	stop := make(chan struct{})
+	hcs.stops[id] = stop
+	go func() {
+		f(stop)
+		hcs.mu.Lock()
+		if hcs.stops[id] == stop {
+			delete(hcs.stops, id)
+		}
+		hcs.mu.Unlock()
+	}()
+}
+
+func (hcs *healthCheckers) stopAll() {
+	hcs.mu.Lock()
+	defer hcs.mu.Unlock()
+	for id, stop := range hcs.stops {
+		close(stop)
+		delete(hcs.stops, id)
+	}
+}
+
+func (hcs *healthCheckers) stop(id DockerContainerID) {
+	hcs.mu.Lock()
+	defer hcs.mu.Unlock()
+	if stop, ok := hcs.stops[id]; ok {
+		close(stop)
+		delete(hcs.stops, id)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func containerWithPort(port, hostPort string) *docker.Container {
+	return &docker.Container{
+		NetworkSettings: &docker.NetworkSettings{
+			Ports: map[docker.Port][]docker.PortBinding{
+				docker.Port(port + "/tcp"): []docker.PortBinding{
+					docker.PortBinding{HostPort: hostPort},
+				},
+			},
+		},
+	}
+}
+
+func TestHTTPHealthCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	container := containerWithPort("80", port)
+
+	hc := &HealthCheck{Type: healthCheckHTTP, Port: 80, Path: "/health", Timeout: 1}
+	if err := hc.check(&dockerMock{}, container); err != nil {
+		t.Error(err)
+	}
+	hc.Path = "/"
+	if err := hc.check(&dockerMock{}, container); err == nil {
+		t.Error("health check must fail on 500")
+	}
+}
+
+func TestTCPHealthCheck(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	hc := &HealthCheck{Type: healthCheckTCP, Port: 5432, Timeout: 1}
+	if err := hc.check(&dockerMock{}, containerWithPort("5432", port)); err != nil {
+		t.Error(err)
+	}
+	l.Close()
+	if err := hc.check(&dockerMock{}, containerWithPort("5432", port)); err == nil {
+		t.Error("health check must fail on closed port")
+	}
+	if err := hc.check(&dockerMock{}, containerWithPort("80", port)); err == nil {
+		t.Error("health check must fail without port binding")
+	}
+}
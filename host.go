@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+const (
+	hostStatusPulling   = "pulling"
+	hostStatusCreating  = "creating"
+	hostStatusRunning   = "running"
+	hostStatusExited    = "exited"
+	hostStatusFailed    = "failed"
+	hostStatusCrashLoop = "crashloop"
+)
+
+// Host is an entry under /apps/<app>/<container>/hosts which represents
+// a replica of the container placed on a host.
+type Host struct {
+	Addr     string `json:"addr"`
+	Status   string `json:"status"`
+	Revision string `json:"revision,omitempty"`
+	ExitCode int    `json:"exitCode,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Restarts int    `json:"restarts,omitempty"`
+}
+
+// inProgress reports whether the replica is still being deployed.
+func (h Host) inProgress() bool {
+	return h.Status == hostStatusPulling || h.Status == hostStatusCreating
+}
+
+func failedHost(err error) Host {
+	return Host{
+		Addr:   hostIP,
+		Status: hostStatusFailed,
+		Error:  err.Error(),
+	}
+}
+
+// exitedHost builds the host entry of a container which has stopped.
+func exitedHost(state docker.State) Host {
+	h := Host{
+		Addr:     hostIP,
+		Status:   hostStatusExited,
+		ExitCode: state.ExitCode,
+		Error:    state.Error,
+	}
+	if state.ExitCode != 0 {
+		h.Status = hostStatusFailed
+		if h.Error == "" {
+			h.Error = fmt.Sprintf("exited with code %d", state.ExitCode)
+		}
+	}
+	return h
+}
+
+// setHostStatus updates this host's entries of the manifest.
+func (s scheduler) setHostStatus(m *Manifest, h Host) error {
+	if h.Revision == "" {
+		h.Revision = m.Revision
+	}
+	value, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	for _, n := range s.ownHostNodes(m) {
+		_, err = s.etcdClient.Update(n.Key, string(value), hostLeaseTTL)
+		if err != nil {
+			logFor(m).Op("status").Error("updating host status failed", "status", h.Status, "err", err)
+		}
+	}
+	switch h.Status {
+	case hostStatusRunning, hostStatusFailed, hostStatusCrashLoop:
+		s.checkRevision(m)
+	}
+	return err
+}
+
+// onContainerDied records the exit of a managed container into its host entry.
+func (s scheduler) onContainerDied(id DockerContainerID) error {
+	container, err := s.dockerClient.InspectContainer(string(id))
+	if err != nil {
+		// the container has already been removed
+		return nil
+	}
+	name := strings.TrimPrefix(container.Name, "/")
+	appName, containerName, ok := parseContainerName(name)
+	if !ok {
+		return nil
+	}
+	current, err := s.dockerClient.InspectContainer(name)
+	if err != nil || current.ID != container.ID {
+		// replaced by a new container
+		return nil
+	}
+	if s.restarts.pending(name) {
+		return nil
+	}
+	m, err := s.getManifest(appName, containerName)
+	if err != nil {
+		return err
+	}
+	h := exitedHost(container.State)
+	h.Revision = containerEnv(container, "DOKKAA_REVISION")
+
+	restarts, ok := s.restarts.next(name, container.State, m.Container.Restart)
+	if !ok {
+		return s.setHostStatus(m, h)
+	}
+	h.Restarts = restarts
+	if restarts >= crashLoopThreshold {
+		h.Status = hostStatusCrashLoop
+	}
+	delay := restartBackoff(restarts - 1)
+	logFor(m).Op("restart").Info("restarting container", "delay", delay, "restarts", restarts)
+	time.AfterFunc(delay, func() {
+		s.restart(m, container.ID, restarts)
+	})
+	return s.setHostStatus(m, h)
+}
+
+func (s scheduler) watchContainerEvents() {
+	c := make(chan *docker.APIEvents)
+	err := s.dockerClient.AddEventListener(c)
+	if err != nil {
+		logger.Op("events").Error("listening docker events failed", "err", err)
+		return
+	}
+	for {
+		select {
+		case <-s.quit:
+			s.dockerClient.RemoveEventListener(c)
+			return
+		case event, ok := <-c:
+			if !ok {
+				return
+			}
+			if event.Status == "die" {
+				s.onContainerDied(DockerContainerID(event.ID))
+			}
+		}
+	}
+}
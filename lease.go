@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+var (
+	// hostLeaseTTL is the TTL in seconds of a host entry under
+	// /apps/<app>/<container>/hosts. Entries are refreshed while the
+	// container is alive, so a crashed host loses its slots after the TTL.
+	hostLeaseTTL uint64 = 30
+
+	// hostCapacity overrides the capacity reported by docker if not zero.
+	hostCapacity Resources
+)
+
+func heartbeatInterval() time.Duration {
+	return time.Second * time.Duration(hostLeaseTTL) / 3
+}
+
+func (s scheduler) heartbeatLoop() {
+	for {
+		s.announceHost()
+		select {
+		case <-s.quit:
+			return
+		case <-time.After(heartbeatInterval()):
+		}
+		s.renewLeases()
+	}
+}
+
+// announceHost tells other hosts that the conductor of this host is alive
+// and publishes the labels, capacity and allocated resources of this host.
+func (s scheduler) announceHost() {
+	_, err := s.etcdClient.Set(rootPath()+"alive", "", hostLeaseTTL)
+	if err != nil {
+		logger.Op("heartbeat").Error("announcing host failed", "err", err)
+	}
+	labels, _ := json.Marshal(hostLabels)
+	_, err = s.etcdClient.Set(rootPath()+"labels", string(labels), 0)
+	if err != nil {
+		logger.Op("heartbeat").Error("publishing labels failed", "err", err)
+	}
+
+	capacity, err := s.hostCapacity()
+	if err != nil {
+		logger.Op("heartbeat").Error("getting capacity failed", "err", err)
+		return
+	}
+	value, _ := json.Marshal(capacity)
+	s.etcdClient.Set(rootPath()+"capacity", string(value), 0)
+	cs, err := s.clusterState()
+	if err != nil {
+		logger.Op("heartbeat").Error("getting cluster state failed", "err", err)
+		return
+	}
+	value, _ = json.Marshal(cs.allocated(hostIP, nil))
+	s.etcdClient.Set(rootPath()+"allocated", string(value), 0)
+}
+
+// hostCapacity returns the capacity of this host given by HOST_CPU and
+// HOST_MEMORY, or reported by docker.
+func (s scheduler) hostCapacity() (Resources, error) {
+	capacity := hostCapacity
+	if capacity.CPU > 0 && capacity.Memory > 0 {
+		return capacity, nil
+	}
+	info, err := s.dockerClient.Info()
+	if err != nil {
+		return capacity, err
+	}
+	if capacity.CPU == 0 {
+		capacity.CPU = float64(info.NCPU)
+	}
+	if capacity.Memory == 0 {
+		capacity.Memory = info.MemTotal
+	}
+	return capacity, nil
+}
+
+// renewLeases refreshes the TTL of every host entry of this host whose
+// container is still being created or is running.
+func (s scheduler) renewLeases() {
+	manifests, err := s.listManifests()
+	if err != nil {
+		logger.Op("heartbeat").Error("listing manifests failed", "err", err)
+		return
+	}
+	for _, m := range manifests {
+		for _, n := range s.ownHostNodes(m) {
+			s.renewLease(m, n)
+		}
+	}
+}
+
+// renewLease refreshes the TTL of the host entry unless the entry has been
+// changed since it was read, so that a status written in the meantime is
+// never overwritten by the stale value.
+func (s scheduler) renewLease(m *Manifest, n *etcd.Node) {
+	var h Host
+	json.Unmarshal([]byte(n.Value), &h)
+	if !h.inProgress() && h.Status != hostStatusCrashLoop && !s.isRunning(m) {
+		return
+	}
+	_, err := s.etcdClient.CompareAndSwap(n.Key, n.Value, hostLeaseTTL, "", n.ModifiedIndex)
+	if err != nil {
+		logFor(m).Op("heartbeat").Warn("renewing lease failed", "key", n.Key, "err", err)
+	}
+}
+
+// ownHostNodes returns this host's entries in the hosts directory of the manifest.
+func (s scheduler) ownHostNodes(m *Manifest) []*etcd.Node {
+	resp, err := s.etcdClient.Get(m.HostsDirKey(), true, true)
+	if err != nil {
+		return nil
+	}
+	var nodes []*etcd.Node
+	for _, n := range resp.Node.Nodes {
+		var h Host
+		err = json.Unmarshal([]byte(n.Value), &h)
+		if err != nil {
+			continue
+		}
+		if h.Addr == hostIP {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+func (s scheduler) isRunning(m *Manifest) bool {
+	c, err := s.dockerClient.InspectContainer(m.Container.Name)
+	if err != nil {
+		return false
+	}
+	return c.State.Running
+}
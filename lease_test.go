@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRenewLeaseStale(t *testing.T) {
+	defer func(ip string) { hostIP = ip }(hostIP)
+	hostIP = "10.0.0.1"
+
+	e := newFakeEtcd()
+	s := NewScheduler(newFakeDocker(), e).(*scheduler)
+	m, _ := NewManifest("blog", "web", `{"Image": "nginx"}`)
+	resp, _ := e.CreateInOrder(m.HostsDirKey(), hostEntry(hostStatusPulling), hostLeaseTTL)
+
+	nodes := s.ownHostNodes(m)
+	if len(nodes) != 1 {
+		t.Fatal(nodes)
+	}
+	// the container becomes running between the read and the renewal
+	e.Update(resp.Node.Key, hostEntry(hostStatusRunning), hostLeaseTTL)
+	s.renewLease(m, nodes[0])
+
+	var h Host
+	json.Unmarshal([]byte(e.value(resp.Node.Key)), &h)
+	if h.Status != hostStatusRunning {
+		t.Error(h.Status)
+	}
+}
+
+func TestRenewLeases(t *testing.T) {
+	defer func(ip string) { hostIP = ip }(hostIP)
+	hostIP = "10.0.0.1"
+
+	e := newFakeEtcd()
+	e.Set("/apps/blog/web/manifest", `{"Image": "nginx"}`, 0)
+	pulling, _ := e.CreateInOrder("/apps/blog/web/hosts", hostEntry(hostStatusPulling), hostLeaseTTL)
+	e.Set("/apps/blog/db/manifest", `{"Image": "postgres"}`, 0)
+	exited, _ := e.CreateInOrder("/apps/blog/db/hosts", hostEntry(hostStatusExited), hostLeaseTTL)
+	e.Set("/apps/blog/cache/manifest", `{"Image": "redis"}`, 0)
+	running, _ := e.CreateInOrder("/apps/blog/cache/hosts", hostEntry(hostStatusRunning), hostLeaseTTL)
+
+	s := NewScheduler(newFakeDocker(runningContainer("blog---cache")), e).(*scheduler)
+	s.renewLeases()
+
+	for _, c := range []struct {
+		key     string
+		index   uint64
+		renewed bool
+	}{
+		{pulling.Node.Key, pulling.Node.ModifiedIndex, true},
+		{exited.Node.Key, exited.Node.ModifiedIndex, false},
+		{running.Node.Key, running.Node.ModifiedIndex, true},
+	} {
+		resp, err := e.Get(c.key, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if renewed := resp.Node.ModifiedIndex != c.index; renewed != c.renewed {
+			t.Errorf("%s: renewed %v, want %v", c.key, renewed, c.renewed)
+		}
+		if resp.Node.TTL != int64(hostLeaseTTL) {
+			t.Errorf("%s: TTL %d", c.key, resp.Node.TTL)
+		}
+	}
+}
+
+func TestAnnounceHost(t *testing.T) {
+	defer func(ip string) { hostIP = ip }(hostIP)
+	defer func(l map[string]string) { hostLabels = l }(hostLabels)
+	defer func(c Resources) { hostCapacity = c }(hostCapacity)
+	hostIP = "10.0.0.1"
+	hostLabels = map[string]string{"zone": "a"}
+	hostCapacity = Resources{CPU: 2, Memory: 1024}
+
+	e := newFakeEtcd()
+	s := NewScheduler(newFakeDocker(), e).(*scheduler)
+	s.announceHost()
+
+	resp, err := e.Get("/hosts/10.0.0.1/alive", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Node.TTL != int64(hostLeaseTTL) {
+		t.Error(resp.Node.TTL)
+	}
+	if v := e.value("/hosts/10.0.0.1/labels"); v != `{"zone":"a"}` {
+		t.Error(v)
+	}
+	var capacity Resources
+	json.Unmarshal([]byte(e.value("/hosts/10.0.0.1/capacity")), &capacity)
+	if capacity != hostCapacity {
+		t.Error(capacity)
+	}
+}
+
+func TestAcquire(t *testing.T) {
+	defer func(ip string) { hostIP = ip }(hostIP)
+	hostIP = "10.0.0.1"
+
+	e := newFakeEtcd()
+	s := NewScheduler(newFakeDocker(), e).(*scheduler)
+	m, _ := NewManifest("blog", "web", `{"Image": "nginx"}`)
+	e.Set(m.ManifestKey(), `{"Image": "nginx"}`, 0)
+
+	ok, err := s.acquire(m)
+	if err != nil || !ok {
+		t.Fatal(ok, err)
+	}
+	nodes := s.ownHostNodes(m)
+	if len(nodes) != 1 || nodes[0].TTL != int64(hostLeaseTTL) {
+		t.Fatal(nodes)
+	}
+	// acquiring again doesn't take another slot
+	ok, _ = s.acquire(m)
+	if !ok || len(s.ownHostNodes(m)) != 1 {
+		t.Error("acquired twice")
+	}
+
+	// the only slot is already held by another host
+	hostIP = "10.0.0.2"
+	ok, _ = s.acquire(m)
+	if ok {
+		t.Error("acquired more than scale")
+	}
+}
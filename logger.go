@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l logLevel) String() string {
+	return levelNames[l]
+}
+
+func parseLogLevel(s string) (logLevel, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return logLevel(i), nil
+		}
+	}
+	return levelInfo, fmt.Errorf("unknown log level %s", s)
+}
+
+func parseLogFormat(s string) (bool, error) {
+	switch s {
+	case "logfmt":
+		return false, nil
+	case "json":
+		return true, nil
+	}
+	return false, errors.New("log format must be logfmt or json")
+}
+
+var (
+	// minLogLevel is given by LOG_LEVEL.
+	minLogLevel = levelInfo
+	// logJSON is set by LOG_FORMAT=json. Lines are written in logfmt otherwise.
+	logJSON = false
+
+	logOutput io.Writer = os.Stderr
+	logMu     sync.Mutex
+
+	logger Logger
+)
+
+// Logger writes structured log lines. Every line has time, level, msg and
+// the host IP, followed by the fields of the logger and of the call.
+type Logger struct {
+	fields []interface{}
+}
+
+// With returns a logger which adds the key-value pairs to every line.
+func (l Logger) With(kv ...interface{}) Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	fields = append(fields, l.fields...)
+	fields = append(fields, kv...)
+	return Logger{fields: fields}
+}
+
+// Op returns a logger tagging lines with the operation.
+func (l Logger) Op(op string) Logger {
+	return l.With("op", op)
+}
+
+func (l Logger) Debug(msg string, kv ...interface{}) { l.log(levelDebug, msg, kv) }
+func (l Logger) Info(msg string, kv ...interface{})  { l.log(levelInfo, msg, kv) }
+func (l Logger) Warn(msg string, kv ...interface{})  { l.log(levelWarn, msg, kv) }
+func (l Logger) Error(msg string, kv ...interface{}) { l.log(levelError, msg, kv) }
+
+// Fatal logs at the error level and exits.
+func (l Logger) Fatal(msg string, kv ...interface{}) {
+	l.log(levelError, msg, kv)
+	os.Exit(1)
+}
+
+func (l Logger) log(level logLevel, msg string, kv []interface{}) {
+	if level < minLogLevel {
+		return
+	}
+	pairs := []interface{}{
+		"time", time.Now().UTC().Format(time.RFC3339),
+		"level", level.String(),
+		"msg", msg,
+		"host", hostIP,
+	}
+	pairs = append(pairs, l.fields...)
+	pairs = append(pairs, kv...)
+	if len(pairs)%2 != 0 {
+		pairs = append(pairs, "")
+	}
+
+	var line []byte
+	if logJSON {
+		line = formatJSON(pairs)
+	} else {
+		line = formatLogfmt(pairs)
+	}
+	logMu.Lock()
+	defer logMu.Unlock()
+	logOutput.Write(line)
+}
+
+func formatLogfmt(pairs []interface{}) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < len(pairs); i += 2 {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(fmt.Sprint(pairs[i]))
+		buf.WriteByte('=')
+		v := formatLogValue(pairs[i+1])
+		if v == "" || strings.ContainsAny(v, " =\"\n\t") {
+			v = strconv.Quote(v)
+		}
+		buf.WriteString(v)
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+func formatJSON(pairs []interface{}) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i := 0; i < len(pairs); i += 2 {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(fmt.Sprint(pairs[i]))
+		buf.Write(k)
+		buf.WriteByte(':')
+		v := pairs[i+1]
+		switch v.(type) {
+		case error, fmt.Stringer:
+			v = formatLogValue(v)
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			b, _ = json.Marshal(fmt.Sprint(v))
+		}
+		buf.Write(b)
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
+
+func formatLogValue(v interface{}) string {
+	switch v := v.(type) {
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	}
+	return fmt.Sprint(v)
+}
+
+// logFor returns a logger tagging lines with the app and the container of
+// the manifest.
+func logFor(m *Manifest) Logger {
+	return logger.With("app", m.AppName, "container", m.ContainerName)
+}
+
+// logForContainer returns a logger tagging lines with the app and the
+// container of a docker container name.
+func logForContainer(name string) Logger {
+	app, container, ok := parseContainerName(strings.TrimPrefix(name, "/"))
+	if !ok {
+		return logger.With("name", name)
+	}
+	return logger.With("app", app, "container", container)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func captureLog(f func()) string {
+	defer func(w io.Writer, level logLevel, j bool) {
+		logOutput = w
+		minLogLevel = level
+		logJSON = j
+	}(logOutput, minLogLevel, logJSON)
+	var buf bytes.Buffer
+	logOutput = &buf
+	f()
+	return buf.String()
+}
+
+func TestLogfmt(t *testing.T) {
+	defer func(ip string) { hostIP = ip }(hostIP)
+	hostIP = "10.0.0.1"
+	m := &Manifest{AppName: "blog", ContainerName: "web"}
+	out := captureLog(func() {
+		logFor(m).Op("acquire").Info("acquired slot", "hosts", []string{"a", "b"}, "err", errors.New("bad thing"))
+	})
+	expected := `msg="acquired slot" host=10.0.0.1 app=blog container=web op=acquire hosts="[a b]" err="bad thing"` + "\n"
+	if !strings.HasPrefix(out, "time=") || !strings.HasSuffix(out, expected) || !strings.Contains(out, " level=info ") {
+		t.Error(out)
+	}
+}
+
+func TestLogJSON(t *testing.T) {
+	out := captureLog(func() {
+		logJSON = true
+		logForContainer("/blog---web").Warn("unhealthy", "err", errors.New("timeout"), "retries", 3)
+	})
+	var v map[string]interface{}
+	err := json.Unmarshal([]byte(out), &v)
+	if err != nil {
+		t.Fatal(out, err)
+	}
+	if v["level"] != "warn" || v["app"] != "blog" || v["container"] != "web" || v["err"] != "timeout" || v["retries"] != 3.0 {
+		t.Error(v)
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	out := captureLog(func() {
+		minLogLevel = levelWarn
+		logger.Info("hidden")
+		logger.Debug("hidden")
+		logger.Error("shown")
+	})
+	if strings.Contains(out, "hidden") || !strings.Contains(out, "shown") {
+		t.Error(out)
+	}
+
+	level, err := parseLogLevel("DEBUG")
+	if err != nil || level != levelDebug {
+		t.Error(level, err)
+	}
+	_, err = parseLogLevel("verbose")
+	if err == nil {
+		t.Error("unknown level must be an error")
+	}
+}
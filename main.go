@@ -2,8 +2,10 @@ package main
 
 import (
 	"flag"
-	"log"
+	"fmt"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/coreos/go-etcd/etcd"
 )
@@ -21,7 +23,7 @@ func getopt(name, def string) string {
 
 func assert(err error) {
 	if err != nil {
-		log.Fatal(err)
+		logger.Fatal(err.Error())
 	}
 }
 
@@ -37,14 +39,49 @@ func newDockerClient() DockerInterface {
 
 func main() {
 	flag.Parse()
+	if flag.NArg() > 0 {
+		err := NewClient(newEtcdClient()).Run(flag.Args())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	hostIP = getopt("HOST_IP", "127.0.0.1")
+	level, err := parseLogLevel(getopt("LOG_LEVEL", "info"))
+	assert(err)
+	minLogLevel = level
+	logJSON, err = parseLogFormat(getopt("LOG_FORMAT", "logfmt"))
+	assert(err)
+	hostLabels = parseLabels(getopt("HOST_LABELS", ""))
+	interval, err := time.ParseDuration(getopt("RECONCILE_INTERVAL", "1m"))
+	assert(err)
+	reconcileInterval = interval
+	ttl, err := strconv.ParseUint(getopt("HOST_LEASE_TTL", "30"), 10, 64)
+	assert(err)
+	hostLeaseTTL = ttl
+	hostCapacity.CPU, err = strconv.ParseFloat(getopt("HOST_CPU", "0"), 64)
+	assert(err)
+	hostCapacity.Memory, err = strconv.ParseInt(getopt("HOST_MEMORY", "0"), 10, 64)
+	assert(err)
+	clusterKey, err = parseClusterKey(getopt("CLUSTER_KEY", ""))
+	assert(err)
+	secretsDir = getopt("SECRETS_DIR", secretsDir)
+	releaseOnShutdown, err = strconv.ParseBool(getopt("RELEASE_ON_SHUTDOWN", "false"))
+	assert(err)
 	scheduler := NewScheduler(newDockerClient(), newEtcdClient())
 	register := NewRegister(newDockerClient(), newEtcdClient())
+	StartAPIServer(getopt("API_ADDR", "127.0.0.1:7070"), NewAPIServer(scheduler, register, NewCluster(newEtcdClient())))
 
 	q1 := scheduler.StartSchedulingLoop()
 	q2 := register.StartDockerEventLoop()
+	signals := shutdownSignals()
 	select {
 	case <-q1:
 	case <-q2:
+	case sig := <-signals:
+		logger.Info("received signal", "signal", sig)
 	}
+	shutdown(scheduler, register, releaseOnShutdown)
 }
@@ -1,39 +1,210 @@
 package main
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 )
 
 const (
 	backendsPortStart = 10000
+
+	updateStrategyRecreate = "recreate"
+	updateStrategyRolling  = "rolling"
+
+	defaultUpdateTimeout = 300
+
+	defaultRollbackFailureRatio = 0.5
+
+	healthCheckHTTP = "http"
+	healthCheckTCP  = "tcp"
+	healthCheckExec = "exec"
+
+	defaultHealthCheckInterval = 10
+	defaultHealthCheckTimeout  = 5
+	defaultHealthCheckRetries  = 3
+
+	restartAlways    = "always"
+	restartOnFailure = "on-failure"
+	restartNever     = "never"
+
+	placementSpread  = "spread"
+	placementBinpack = "binpack"
+	placementRandom  = "random"
+
+	constraintEquals = "equals"
+	constraintIn     = "in"
+	constraintNotIn  = "not-in"
+
+	volumeTypeVolume = "volume"
+	volumeTypeBind   = "bind"
+	volumeTypeTmpfs  = "tmpfs"
 )
 
+// Port binds ContainerPort to a fixed HostPort. Protocol is "tcp" (default)
+// or "udp", and HostIP defaults to all interfaces.
 type Port struct {
 	HostPort      int
 	ContainerPort int
+	Protocol      string
+	HostIP        string
+}
+
+// conflicts reports whether both ports bind the same host port.
+func (p Port) conflicts(o Port) bool {
+	if p.HostPort == 0 || p.HostPort != o.HostPort {
+		return false
+	}
+	if protocolOf(p) != protocolOf(o) {
+		return false
+	}
+	return p.HostIP == "" || o.HostIP == "" || p.HostIP == "0.0.0.0" || o.HostIP == "0.0.0.0" || p.HostIP == o.HostIP
+}
+
+func protocolOf(p Port) string {
+	if p.Protocol == "" {
+		return "tcp"
+	}
+	return p.Protocol
 }
 
+// Srv is a service announced to skydns. Protocol is "tcp" (default) or "udp".
 type Srv struct {
-	Port int
-	Role string
+	Port     int
+	Role     string
+	Protocol string
 }
 
-type Container struct {
-	Image    string
-	Name     string
-	Scale    int
-	Env      map[string]string
-	Links    []string
+// UpdatePolicy describes how replicas are replaced when the manifest changes.
+// With the "rolling" strategy at most MaxUnavailable replicas are stopped
+// and at most MaxSurge extra replicas are started at the same time.
+type UpdatePolicy struct {
+	Strategy        string
+	MaxUnavailable  int
+	MaxSurge        int
+	MinReadySeconds int
+	Timeout         int
+}
+
+func (p *UpdatePolicy) isRolling() bool {
+	return p != nil && p.Strategy == updateStrategyRolling
+}
+
+// RollbackPolicy enables automatic rollback to the last known-good revision
+// once FailureRatio of the replicas failed to deploy.
+type RollbackPolicy struct {
+	FailureRatio float64
+}
+
+// HealthCheck describes how the conductor checks a container. "http" and
+// "tcp" checks connect to the host port published for Port, "exec" checks
+// run Command inside the container. The container is unhealthy after
+// Retries consecutive failures.
+type HealthCheck struct {
+	Type     string
+	Port     int
+	Path     string
 	Command  []string
-	Services map[string]Srv
+	Interval int
+	Timeout  int
+	Retries  int
+}
+
+// RestartPolicy decides whether the conductor restarts a container which
+// died. With "on-failure" the container is restarted only when it exited
+// with a non-zero code, at most MaxRetries times in a row (0 for no limit).
+type RestartPolicy struct {
+	Policy     string
+	MaxRetries int
+}
+
+// Constraint restricts hosts by their labels. "equals" requires Label to
+// be Value, "in" and "not-in" require it to be, or not to be, one of Values.
+type Constraint struct {
+	Label  string
+	Op     string
+	Value  string
+	Values []string
+}
+
+// Resources is an amount of CPU cores and memory in bytes.
+type Resources struct {
+	CPU    float64 `json:"cpu"`
+	Memory int64   `json:"memory"`
+}
+
+// Limits are passed to docker to bound the resources a container uses.
+// Memory and MemorySwap are in bytes.
+type Limits struct {
+	Memory     int64
+	MemorySwap int64
+	CPUShares  int64
+	CPUQuota   int64
+	CPUPeriod  int64
+	Cpuset     string
+	PidsLimit  int64
+}
+
+// Volume is mounted at Target in the container. Source is the name of a
+// docker volume for "volume" (default), or a host path for "bind".
+// "tmpfs" volumes have no source.
+type Volume struct {
+	Type     string
+	Source   string
+	Target   string
+	ReadOnly bool
+}
+
+// SecretRef injects the secret Name of the app into the container as the
+// env variable Env and/or the read-only file File.
+type SecretRef struct {
+	Name string
+	Env  string
+	File string
+}
+
+type Container struct {
+	Image       string
+	Name        string
+	Scale       int
+	Env         map[string]string
+	Links       []string
+	Command     []string
+	Services    map[string]Srv
+	Ports       []Port
+	Update      *UpdatePolicy
+	Rollback    *RollbackPolicy
+	HealthCheck *HealthCheck
+	Restart     *RestartPolicy
+	Placement   string
+	// Constraints must be satisfied by the labels of a host. Affinity and
+	// AntiAffinity refer to other containers as "<app>" or
+	// "<app>/<container>" which must, or must not, run on the same host.
+	Constraints  []Constraint
+	Affinity     []string
+	AntiAffinity []string
+	// Resources requested by a replica. Hosts without enough free
+	// capacity are not chosen.
+	Resources *Resources
+	Limits    *Limits
+	Volumes   []Volume
+	// KeepVolumes keeps anonymous volumes of the container when it is
+	// removed.
+	KeepVolumes bool
+	// Sticky replicas prefer the hosts which ran the container before,
+	// where named volumes and bind mounts still hold their data.
+	Sticky  bool
+	Secrets []SecretRef
 }
 
 type Manifest struct {
 	AppName       string
 	ContainerName string
+	Revision      string
 	Container     *Container
 }
 
@@ -41,6 +212,7 @@ func NewManifest(app, container, val string) (*Manifest, error) {
 	m := Manifest{
 		AppName:       app,
 		ContainerName: container,
+		Revision:      revision(val),
 	}
 	var c Container
 	err := json.Unmarshal([]byte(val), &c)
@@ -52,10 +224,65 @@ func NewManifest(app, container, val string) (*Manifest, error) {
 	if m.Container.Scale == 0 {
 		m.Container.Scale = 1
 	}
+	if u := m.Container.Update; u != nil {
+		if u.Strategy == "" {
+			u.Strategy = updateStrategyRecreate
+		}
+		if u.MaxUnavailable == 0 && u.MaxSurge == 0 {
+			u.MaxUnavailable = 1
+		}
+		if u.Timeout == 0 {
+			u.Timeout = defaultUpdateTimeout
+		}
+	}
+	if r := m.Container.Rollback; r != nil && r.FailureRatio == 0 {
+		r.FailureRatio = defaultRollbackFailureRatio
+	}
+	if hc := m.Container.HealthCheck; hc != nil {
+		if hc.Interval == 0 {
+			hc.Interval = defaultHealthCheckInterval
+		}
+		if hc.Timeout == 0 {
+			hc.Timeout = defaultHealthCheckTimeout
+		}
+		if hc.Retries == 0 {
+			hc.Retries = defaultHealthCheckRetries
+		}
+	}
+	for i := range m.Container.Ports {
+		if m.Container.Ports[i].Protocol == "" {
+			m.Container.Ports[i].Protocol = "tcp"
+		}
+	}
+	for i := range m.Container.Volumes {
+		if m.Container.Volumes[i].Type == "" {
+			m.Container.Volumes[i].Type = volumeTypeVolume
+		}
+	}
+	if m.Container.Placement == "" {
+		m.Container.Placement = placementSpread
+	}
+	if m.Container.Restart == nil {
+		m.Container.Restart = &RestartPolicy{}
+	}
+	if m.Container.Restart.Policy == "" {
+		m.Container.Restart.Policy = restartAlways
+	}
+	userEnv := m.Container.Env
 	m.Container.Env = map[string]string{}
 	m.Container.Env["DOKKAA_APP_NAME"] = app
+	m.Container.Env["DOKKAA_REVISION"] = m.Revision
+	if m.Container.HealthCheck != nil {
+		hc, _ := json.Marshal(m.Container.HealthCheck)
+		m.Container.Env["DOKKAA_HEALTHCHECK"] = string(hc)
+	}
 	for k, s := range m.Container.Services {
+		if s.Protocol == "" {
+			s.Protocol = "tcp"
+			m.Container.Services[k] = s
+		}
 		m.Container.Env["DOKKAA_SERVICE_"+k] = strconv.Itoa(s.Port)
+		m.Container.Env["DOKKAA_PROTOCOL_"+k] = s.Protocol
 		if s.Role != "" {
 			m.Container.Env["DOKKAA_ROLE_"+k] = s.Role
 		}
@@ -67,10 +294,51 @@ func NewManifest(app, container, val string) (*Manifest, error) {
 		m.Container.Env[s+"_ADDR"] = "backends"
 		m.Container.Env[s+"_PORT"] = strconv.Itoa(port)
 	}
+	err = mergeEnv(m.Container.Env, userEnv)
+	if err != nil {
+		return &m, err
+	}
 
 	return &m, nil
 }
 
+// mergeEnv adds env declared in the manifest to the generated env.
+// Generated variables take precedence, so declaring one of them, or any
+// variable prefixed with DOKKAA_, is an error.
+func mergeEnv(generated, user map[string]string) error {
+	keys := []string{}
+	for k := range user {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if _, ok := generated[k]; ok || strings.HasPrefix(k, "DOKKAA_") {
+			return fmt.Errorf("env %s conflicts with a variable generated by dokkaa", k)
+		}
+		generated[k] = user[k]
+	}
+	return nil
+}
+
+// revision returns an identifier of the manifest value.
+func revision(val string) string {
+	sum := sha1.Sum([]byte(val))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// parseContainerName splits a docker container name created by dokkaa
+// ("<app>---<container>") into app and container names.
+func parseContainerName(name string) (app, container string, ok bool) {
+	if strings.HasPrefix(name, "__") {
+		return "", "", false
+	}
+	parts := strings.SplitN(name, "---", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
 func (m *Manifest) keyRoot() string {
 	a := m.AppName
 	c := m.ContainerName
@@ -84,3 +352,23 @@ func (m *Manifest) ManifestKey() string {
 func (m *Manifest) HostsDirKey() string {
 	return m.keyRoot() + "hosts"
 }
+
+func (m *Manifest) UpdateDirKey() string {
+	return m.keyRoot() + "update"
+}
+
+func (m *Manifest) StatusKey() string {
+	return m.keyRoot() + "status"
+}
+
+func (m *Manifest) StickyDirKey() string {
+	return m.keyRoot() + "sticky"
+}
+
+func (m *Manifest) RevisionsDirKey() string {
+	return m.keyRoot() + "revisions"
+}
+
+func (m *Manifest) RevisionKey() string {
+	return m.RevisionsDirKey() + "/" + m.Revision
+}
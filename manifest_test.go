@@ -0,0 +1,85 @@
+package main
+
+import "testing"
+
+func TestNewManifest(t *testing.T) {
+	m, err := NewManifest("app", "web", `{"Image": "nginx", "Services": {"http": {"Port": 80}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Container.Name != "app---web" {
+		t.Error(m.Container.Name)
+	}
+	if m.Container.Scale != 1 {
+		t.Error(m.Container.Scale)
+	}
+	if m.Container.Env["DOKKAA_SERVICE_http"] != "80" {
+		t.Error(m.Container.Env)
+	}
+	if m.Container.Env["DOKKAA_REVISION"] != m.Revision || m.Revision == "" {
+		t.Error(m.Revision)
+	}
+}
+
+func TestNewManifestUpdatePolicy(t *testing.T) {
+	m, err := NewManifest("app", "web", `{"Image": "nginx", "Update": {"Strategy": "rolling"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := m.Container.Update
+	if !u.isRolling() || u.MaxUnavailable != 1 || u.MaxSurge != 0 || u.Timeout != defaultUpdateTimeout {
+		t.Error(u)
+	}
+
+	m2, _ := NewManifest("app", "web", `{"Image": "nginx:1.7", "Update": {"Strategy": "rolling"}}`)
+	if m.Revision == m2.Revision {
+		t.Error("revision must change with the manifest")
+	}
+}
+
+func TestParseContainerName(t *testing.T) {
+	app, container, ok := parseContainerName("app---web")
+	if !ok || app != "app" || container != "web" {
+		t.Error(app, container, ok)
+	}
+	for _, name := range []string{"web", "__ambassador", "---web"} {
+		if _, _, ok := parseContainerName(name); ok {
+			t.Error(name)
+		}
+	}
+}
+
+func TestNewManifestServiceProtocol(t *testing.T) {
+	m, err := NewManifest("app", "dns", `{"Services": {"dns": {"Port": 53, "Protocol": "udp"}, "http": {"Port": 80}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Container.Env["DOKKAA_PROTOCOL_dns"] != "udp" || m.Container.Env["DOKKAA_PROTOCOL_http"] != "tcp" {
+		t.Error(m.Container.Env)
+	}
+	if m.Container.Services["http"].Protocol != "tcp" {
+		t.Error(m.Container.Services)
+	}
+}
+
+func TestNewManifestEnv(t *testing.T) {
+	m, err := NewManifest("app", "web", `{"Env": {"RAILS_ENV": "production"}, "Links": ["db"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := m.Container.Env
+	if env["RAILS_ENV"] != "production" || env["DOKKAA_APP_NAME"] != "app" || env["SERVICE_DB_PORT"] != "10000" {
+		t.Error(env)
+	}
+
+	conflicts := []string{
+		`{"Env": {"DOKKAA_APP_NAME": "other"}}`,
+		`{"Env": {"DOKKAA_ANYTHING": "x"}}`,
+		`{"Env": {"SERVICE_DB_ADDR": "db"}, "Links": ["db"]}`,
+	}
+	for _, val := range conflicts {
+		if _, err := NewManifest("app", "web", val); err == nil {
+			t.Error("conflict must be an error: ", val)
+		}
+	}
+}
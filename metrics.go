@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics are exposed at /metrics in the Prometheus text format.
+var (
+	acquireTotal = newCounter("dokkaa_acquire_total",
+		"Attempts to acquire a slot by result: acquired, lost, ineligible, unschedulable or error.", "result")
+	scheduleTotal = newCounter("dokkaa_schedule_total",
+		"Containers scheduled by result: running or failed.", "result")
+	imagePullsTotal = newCounter("dokkaa_image_pulls_total",
+		"Image pulls by result: ok or error.", "result")
+	imagePullDuration = newHistogram("dokkaa_image_pull_duration_seconds",
+		"Time spent pulling images.", []float64{0.1, 0.5, 1, 5, 10, 30, 60, 120, 300})
+	registerAddTotal = newCounter("dokkaa_register_add_total",
+		"Containers added to the register by result: ok or error.", "result")
+	registerDeleteTotal = newCounter("dokkaa_register_delete_total",
+		"Containers deleted from the register by result: ok or error.", "result")
+	etcdWatchEventsTotal = newCounter("dokkaa_etcd_watch_events_total",
+		"Events received from etcd watches by prefix.", "prefix")
+	etcdWatchReconnectsTotal = newCounter("dokkaa_etcd_watch_reconnects_total",
+		"Times etcd watches were reconnected by prefix.", "prefix")
+	reconcileDuration = newHistogram("dokkaa_reconcile_duration_seconds",
+		"Time spent reconciling.", []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30})
+)
+
+type metric interface {
+	write(w io.Writer)
+}
+
+var (
+	metricsMu sync.Mutex
+	registry  []metric
+)
+
+func registerMetric(m metric) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	registry = append(registry, m)
+}
+
+// counter is a Prometheus counter partitioned by label values.
+type counter struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newCounter(name, help string, labels ...string) *counter {
+	c := &counter{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: map[string]float64{},
+	}
+	registerMetric(c)
+	return c
+}
+
+// Inc increments the counter of the label values given in the order of
+// the labels of the counter.
+func (c *counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *counter) Add(v float64, values ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[formatLabelPairs(c.labels, values)] += v
+}
+
+func (c *counter) Value(values ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[formatLabelPairs(c.labels, values)]
+}
+
+func (c *counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	keys := []string{}
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, k, formatFloat(c.values[k]))
+	}
+}
+
+// histogram is a Prometheus histogram with fixed upper bounds of buckets.
+type histogram struct {
+	name    string
+	help    string
+	buckets []float64
+	mu      sync.Mutex
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(name, help string, buckets []float64) *histogram {
+	h := &histogram{
+		name:    name,
+		help:    help,
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+	registerMetric(h)
+	return h
+}
+
+func (h *histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// ObserveSince observes seconds elapsed since start.
+func (h *histogram) ObserveSince(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+func (h *histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for i, b := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.name, formatFloat(b), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", h.name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", h.name, h.count)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabelPairs(labels, values []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(labels))
+	for i, l := range labels {
+		v := ""
+		if i < len(values) {
+			v = values[i]
+		}
+		pairs[i] = l + `="` + labelValueEscaper.Replace(v) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func writeMetrics(w io.Writer) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	for _, m := range registry {
+		m.write(w)
+	}
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetrics(w)
+}
+
+// resultOf labels the result of an operation by its error.
+func resultOf(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCounterWrite(t *testing.T) {
+	c := &counter{
+		name:   "test_total",
+		help:   "Test counter.",
+		labels: []string{"result"},
+		values: map[string]float64{},
+	}
+	c.Inc("ok")
+	c.Inc("ok")
+	c.Add(0.5, `"err"`)
+
+	var buf bytes.Buffer
+	c.write(&buf)
+	expected := `# HELP test_total Test counter.
+# TYPE test_total counter
+test_total{result="\"err\""} 0.5
+test_total{result="ok"} 2
+`
+	if buf.String() != expected {
+		t.Error(buf.String())
+	}
+	if c.Value("ok") != 2 {
+		t.Error(c.Value("ok"))
+	}
+}
+
+func TestHistogramWrite(t *testing.T) {
+	h := &histogram{
+		name:    "test_seconds",
+		help:    "Test histogram.",
+		buckets: []float64{0.5, 1},
+		counts:  make([]uint64, 2),
+	}
+	h.Observe(0.25)
+	h.Observe(0.75)
+	h.Observe(2)
+
+	var buf bytes.Buffer
+	h.write(&buf)
+	expected := `# HELP test_seconds Test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{le="0.5"} 1
+test_seconds_bucket{le="1"} 2
+test_seconds_bucket{le="+Inf"} 3
+test_seconds_sum 3
+test_seconds_count 3
+`
+	if buf.String() != expected {
+		t.Error(buf.String())
+	}
+}
+
+func TestWriteMetrics(t *testing.T) {
+	var buf bytes.Buffer
+	writeMetrics(&buf)
+	for _, name := range []string{"dokkaa_acquire_total", "dokkaa_image_pull_duration_seconds", "dokkaa_register_add_total"} {
+		if !strings.Contains(buf.String(), "# TYPE "+name) {
+			t.Error(name, "is not exposed")
+		}
+	}
+}
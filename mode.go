@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Hosts in a mode stored at /hosts/<ip>/mode don't acquire new slots.
+// Drained hosts also release the slots they hold so that other hosts
+// acquire them, and then remove the containers.
+const (
+	hostModeCordon = "cordon"
+	hostModeDrain  = "drain"
+)
+
+func hostModeKey(ip string) string {
+	return "/hosts/" + ip + "/mode"
+}
+
+// setHostMode sets the mode of the host, or makes the host schedulable
+// again if mode is empty.
+func setHostMode(cli EtcdInterface, ip, mode string) error {
+	switch mode {
+	case "":
+		_, err := cli.Delete(hostModeKey(ip), false)
+		if err != nil && !isKeyNotFound(err) {
+			return err
+		}
+		return nil
+	case hostModeCordon, hostModeDrain:
+		_, err := cli.Set(hostModeKey(ip), mode, 0)
+		return err
+	}
+	return fmt.Errorf("unknown host mode %s", mode)
+}
+
+// hostMode returns the mode of this host.
+func (s scheduler) hostMode() string {
+	resp, err := s.etcdClient.Get(hostModeKey(hostIP), false, false)
+	if err != nil {
+		return ""
+	}
+	return resp.Node.Value
+}
+
+func (s scheduler) Cordon() error {
+	return setHostMode(s.etcdClient, hostIP, hostModeCordon)
+}
+
+func (s scheduler) Uncordon() error {
+	return setHostMode(s.etcdClient, hostIP, "")
+}
+
+// Drain puts this host in the drain mode, which watchMode executes.
+func (s scheduler) Drain() error {
+	return setHostMode(s.etcdClient, hostIP, hostModeDrain)
+}
+
+// watchMode releases all slots of this host once it is drained.
+func (s scheduler) watchMode() {
+	watcher := NewEtcdWatcher(s.etcdClient)
+	recv := watcher.Watch(hostModeKey(hostIP), false, s.quit)
+	for resp := range recv {
+		if resp.Node == nil || resp.Node.Value != hostModeDrain {
+			continue
+		}
+		logger.Op("drain").Info("draining")
+		s.mu.Lock()
+		err := s.releaseAll()
+		s.mu.Unlock()
+		if err != nil {
+			logger.Op("drain").Error("draining failed", "err", err)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSchedulable(t *testing.T) {
+	cs := &clusterState{
+		modes: map[string]string{
+			"10.0.0.2": hostModeCordon,
+			"10.0.0.3": hostModeDrain,
+		},
+	}
+	expects := map[string]bool{
+		"10.0.0.1": true,
+		"10.0.0.2": false,
+		"10.0.0.3": false,
+	}
+	for ip, expected := range expects {
+		if cs.schedulable(ip) != expected {
+			t.Error(ip)
+		}
+	}
+}
+
+func TestSetHostModeUnknown(t *testing.T) {
+	err := setHostMode(&etcdMock{}, "10.0.0.1", "maintenance")
+	if err == nil {
+		t.Error("unknown mode must be an error")
+	}
+}
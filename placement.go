@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"hash/fnv"
+	"path"
+	"sort"
+)
+
+// PlacementStrategy decides which hosts run the replicas of a manifest.
+// Every host ranks the same candidates in the same order, so that only the
+// chosen hosts try to acquire the slots.
+type PlacementStrategy interface {
+	// Rank returns candidates ordered from the most preferred host.
+	// loads is the number of containers running on each host.
+	Rank(m *Manifest, candidates []string, loads map[string]int) []string
+}
+
+var placementStrategies = map[string]PlacementStrategy{
+	placementSpread:  spreadStrategy{},
+	placementBinpack: binpackStrategy{},
+	placementRandom:  randomStrategy{},
+}
+
+func NewPlacementStrategy(name string) (PlacementStrategy, error) {
+	strategy, ok := placementStrategies[name]
+	if !ok {
+		return nil, errors.New("unknown placement strategy: " + name)
+	}
+	return strategy, nil
+}
+
+type hostOrder struct {
+	hosts []string
+	less  func(a, b string) bool
+}
+
+func (o hostOrder) Len() int           { return len(o.hosts) }
+func (o hostOrder) Swap(i, j int)      { o.hosts[i], o.hosts[j] = o.hosts[j], o.hosts[i] }
+func (o hostOrder) Less(i, j int) bool { return o.less(o.hosts[i], o.hosts[j]) }
+
+func sortHosts(candidates []string, less func(a, b string) bool) []string {
+	hosts := make([]string, len(candidates))
+	copy(hosts, candidates)
+	sort.Sort(hostOrder{hosts: hosts, less: less})
+	return hosts
+}
+
+// spreadStrategy prefers hosts running fewer containers.
+type spreadStrategy struct{}
+
+func (spreadStrategy) Rank(m *Manifest, candidates []string, loads map[string]int) []string {
+	return sortHosts(candidates, func(a, b string) bool {
+		if loads[a] != loads[b] {
+			return loads[a] < loads[b]
+		}
+		return a < b
+	})
+}
+
+// binpackStrategy prefers hosts running more containers.
+type binpackStrategy struct{}
+
+func (binpackStrategy) Rank(m *Manifest, candidates []string, loads map[string]int) []string {
+	return sortHosts(candidates, func(a, b string) bool {
+		if loads[a] != loads[b] {
+			return loads[a] > loads[b]
+		}
+		return a < b
+	})
+}
+
+// randomStrategy orders hosts randomly, seeded by the manifest so that all
+// hosts agree on the order.
+type randomStrategy struct{}
+
+func (randomStrategy) Rank(m *Manifest, candidates []string, loads map[string]int) []string {
+	seed := m.Container.Name + "/" + m.Revision + "/"
+	return sortHosts(candidates, func(a, b string) bool {
+		ha, hb := hash(seed+a), hash(seed+b)
+		if ha != hb {
+			return ha < hb
+		}
+		return a < b
+	})
+}
+
+func hash(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+// chosen reports whether the placement strategy of the manifest chooses
+// this host, among the eligible hosts, for one of the slots not acquired
+// yet.
+func (s scheduler) chosen(m *Manifest) (bool, error) {
+	strategy, err := NewPlacementStrategy(m.Container.Placement)
+	if err != nil {
+		return false, err
+	}
+	holders, _ := s.getHosts(m)
+	needed := m.Container.Scale - len(holders)
+	if needed <= 0 {
+		return false, nil
+	}
+
+	cls := NewCluster(s.etcdClient)
+	loads, err := cls.HostLoads()
+	if err != nil {
+		return false, err
+	}
+	cs, err := s.clusterState()
+	if err != nil {
+		return false, err
+	}
+	var candidates []string
+	for _, ip := range cls.GetHosts() {
+		if !containsString(holders, ip) && cs.schedulable(ip) && cs.eligible(m, ip) {
+			candidates = append(candidates, ip)
+		}
+	}
+	ranked := strategy.Rank(m, candidates, loads)
+	if m.Container.Sticky {
+		ranked = stickyFirst(ranked, s.stickyHosts(m))
+	}
+	if len(ranked) > needed {
+		ranked = ranked[:needed]
+	}
+	return containsString(ranked, hostIP), nil
+}
+
+// stickyHosts returns hosts which ran the sticky manifest before.
+func (s scheduler) stickyHosts(m *Manifest) []string {
+	var hosts []string
+	resp, err := s.etcdClient.Get(m.StickyDirKey(), false, false)
+	if err != nil {
+		return hosts
+	}
+	for _, n := range resp.Node.Nodes {
+		hosts = append(hosts, path.Base(n.Key))
+	}
+	return hosts
+}
+
+// stickyFirst moves sticky hosts to the front keeping the ranked order.
+func stickyFirst(ranked, sticky []string) []string {
+	var first, rest []string
+	for _, h := range ranked {
+		if containsString(sticky, h) {
+			first = append(first, h)
+		} else {
+			rest = append(rest, h)
+		}
+	}
+	return append(first, rest...)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
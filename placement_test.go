@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSpreadStrategy(t *testing.T) {
+	loads := map[string]int{"10.0.0.1": 3, "10.0.0.2": 1, "10.0.0.3": 1}
+	ranked := spreadStrategy{}.Rank(nil, []string{"10.0.0.1", "10.0.0.3", "10.0.0.2", "10.0.0.4"}, loads)
+	expected := []string{"10.0.0.4", "10.0.0.2", "10.0.0.3", "10.0.0.1"}
+	if !reflect.DeepEqual(ranked, expected) {
+		t.Error(ranked)
+	}
+}
+
+func TestBinpackStrategy(t *testing.T) {
+	loads := map[string]int{"10.0.0.1": 3, "10.0.0.2": 1, "10.0.0.3": 1}
+	ranked := binpackStrategy{}.Rank(nil, []string{"10.0.0.3", "10.0.0.2", "10.0.0.1", "10.0.0.4"}, loads)
+	expected := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"}
+	if !reflect.DeepEqual(ranked, expected) {
+		t.Error(ranked)
+	}
+}
+
+func TestRandomStrategy(t *testing.T) {
+	m, _ := NewManifest("app", "web", `{"Image": "nginx", "Placement": "random"}`)
+	hosts := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"}
+	reversed := []string{"10.0.0.4", "10.0.0.3", "10.0.0.2", "10.0.0.1"}
+	a := randomStrategy{}.Rank(m, hosts, nil)
+	b := randomStrategy{}.Rank(m, reversed, nil)
+	if !reflect.DeepEqual(a, b) {
+		t.Error("every host must agree on the order", a, b)
+	}
+	if !reflect.DeepEqual(hosts, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"}) {
+		t.Error("candidates must not be modified")
+	}
+}
+
+func TestNewPlacementStrategy(t *testing.T) {
+	for _, name := range []string{placementSpread, placementBinpack, placementRandom} {
+		if _, err := NewPlacementStrategy(name); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := NewPlacementStrategy("unknown"); err == nil {
+		t.Error("unknown strategy must be an error")
+	}
+}
+
+func TestStickyFirst(t *testing.T) {
+	ranked := stickyFirst([]string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"}, []string{"10.0.0.4", "10.0.0.2", "10.0.0.9"})
+	expected := []string{"10.0.0.2", "10.0.0.4", "10.0.0.1", "10.0.0.3"}
+	if !reflect.DeepEqual(ranked, expected) {
+		t.Error(ranked)
+	}
+}
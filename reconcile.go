@@ -0,0 +1,179 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/fsouza/go-dockerclient"
+)
+
+var (
+	reconcileInterval = time.Minute
+)
+
+// Reconcile compares every manifest under /apps with the containers
+// docker knows about on this host. Containers of manifests this host has
+// acquired are started if they are missing or restarted according to
+// their restart policy if they have stopped, and managed containers whose
+// manifest no longer exists are removed. Containers being updated are left
+// to the update. Slots of under-replicated manifests are acquired if the
+// placement strategy chooses this host, and all slots are released if this
+// host is drained.
+func (s scheduler) Reconcile() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer reconcileDuration.ObserveSince(time.Now())
+
+	nodes, err := s.manifestNodes()
+	if err != nil {
+		logger.Op("reconcile").Error("listing manifests failed", "err", err)
+		return err
+	}
+	containers, err := s.managedContainers()
+	if err != nil {
+		logger.Op("reconcile").Error("listing containers failed", "err", err)
+		return err
+	}
+
+	// containers of manifests which fail to parse are known as well, so a
+	// rejected edit doesn't take down the running replicas
+	known := map[string]bool{}
+	for _, n := range nodes {
+		appName, containerName, _, _ := keySubMatch(n.Key)
+		known[appName+"---"+containerName] = true
+	}
+
+	draining := s.hostMode() == hostModeDrain
+	for _, m := range parseManifests(nodes) {
+		if m.Validate() != nil || s.updates.updating(m.Container.Name) {
+			continue
+		}
+		included, _ := s.hostsIncluded(m)
+		if included && draining {
+			s.releaseContainer(m)
+			continue
+		}
+		if !included {
+			s.acquireUnderReplicated(m)
+			continue
+		}
+		c, ok := containers[m.Container.Name]
+		if ok {
+			if !isContainerUp(c) {
+				// let the restart policy decide
+				s.onContainerDied(DockerContainerID(c.ID))
+			}
+			continue
+		}
+		logFor(m).Op("reconcile").Info("starting container")
+		s.Schedule(m)
+	}
+
+	for name, c := range containers {
+		if known[name] || s.updates.updating(strings.TrimSuffix(name, surgeSuffix)) {
+			continue
+		}
+		logForContainer(name).Op("reconcile").Info("removing orphaned container")
+		err = s.dockerClient.RemoveContainer(docker.RemoveContainerOptions{
+			ID:    c.ID,
+			Force: true,
+		})
+		if err != nil {
+			logForContainer(name).Op("reconcile").Error("removing orphaned container failed", "err", err)
+		}
+	}
+	return nil
+}
+
+func (s scheduler) acquireUnderReplicated(m *Manifest) {
+	hosts, _ := s.getHosts(m)
+	if len(hosts) >= m.Container.Scale {
+		return
+	}
+	logFor(m).Op("reconcile").Info("under replicated", "scale", m.Container.Scale, "hosts", hosts)
+	s.tryAcquire(m)
+}
+
+func (s scheduler) reconcileLoop() {
+	for {
+		s.Reconcile()
+		select {
+		case <-s.quit:
+			return
+		case <-time.After(reconcileInterval):
+		}
+	}
+}
+
+// listManifests returns all manifests stored under /apps.
+func (s scheduler) listManifests() ([]*Manifest, error) {
+	nodes, err := s.manifestNodes()
+	if err != nil {
+		return nil, err
+	}
+	return parseManifests(nodes), nil
+}
+
+// manifestNodes returns nodes of all manifest keys under /apps.
+func (s scheduler) manifestNodes() ([]*etcd.Node, error) {
+	resp, err := s.etcdClient.Get("/apps", false, true)
+	if err != nil {
+		if isKeyNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var nodes []*etcd.Node
+	for _, app := range resp.Node.Nodes {
+		for _, c := range app.Nodes {
+			for _, n := range c.Nodes {
+				_, _, file, _ := keySubMatch(n.Key)
+				if file == "manifest" {
+					nodes = append(nodes, n)
+				}
+			}
+		}
+	}
+	return nodes, nil
+}
+
+// parseManifests returns manifests of the nodes skipping invalid ones.
+func parseManifests(nodes []*etcd.Node) []*Manifest {
+	var manifests []*Manifest
+	for _, n := range nodes {
+		appName, containerName, _, _ := keySubMatch(n.Key)
+		m, err := NewManifest(appName, containerName, n.Value)
+		if err != nil {
+			logger.With("app", appName, "container", containerName).Warn("invalid manifest", "err", err)
+			continue
+		}
+		manifests = append(manifests, m)
+	}
+	return manifests
+}
+
+// managedContainers returns containers on this host which are managed by
+// dokkaa, keyed by container name.
+func (s scheduler) managedContainers() (map[string]docker.APIContainers, error) {
+	containers, err := s.dockerClient.ListContainers(docker.ListContainersOptions{All: true})
+	if err != nil {
+		return nil, err
+	}
+
+	managed := map[string]docker.APIContainers{}
+	for _, c := range containers {
+		for _, n := range c.Names {
+			name := strings.TrimPrefix(n, "/")
+			if _, _, ok := parseContainerName(name); ok {
+				managed[name] = c
+			}
+		}
+	}
+	return managed, nil
+}
+
+func isContainerUp(c docker.APIContainers) bool {
+	return strings.HasPrefix(c.Status, "Up")
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func hostEntry(status string) string {
+	value, _ := json.Marshal(Host{Addr: hostIP, Status: status})
+	return string(value)
+}
+
+func TestReconcile(t *testing.T) {
+	defer func(ip string) { hostIP = ip }(hostIP)
+	hostIP = "10.0.0.1"
+
+	e := newFakeEtcd()
+	// a rejected edit of a running container
+	e.Set("/apps/blog/web/manifest", `{"Image": "nginx", "Env": {"DOKKAA_APP_NAME": "x"}}`, 0)
+	e.Set("/apps/blog/db/manifest", `{"Image": "postgres"}`, 0)
+	e.CreateInOrder("/apps/blog/db/hosts", hostEntry(hostStatusRunning), hostLeaseTTL)
+	e.Set("/apps/blog/cache/manifest", `{"Image": "redis"}`, 0)
+	e.CreateInOrder("/apps/blog/cache/hosts", hostEntry(hostStatusRunning), hostLeaseTTL)
+
+	d := newFakeDocker(
+		runningContainer("blog---web"),
+		runningContainer("blog---db"),
+		runningContainer("blog---gone"),
+		runningContainer("unmanaged"),
+	)
+	s := NewScheduler(d, e)
+	err := s.Reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(d.removed, []string{"blog---gone"}) {
+		t.Error(d.removed)
+	}
+	for _, name := range []string{"blog---web", "blog---db", "blog---cache", "unmanaged"} {
+		c, err := d.InspectContainer(name)
+		if err != nil || !c.State.Running {
+			t.Error(name, "must be running")
+		}
+	}
+}
@@ -1,10 +1,10 @@
 package main
 
 import (
-	_ "encoding/json"
-	"log"
+	"encoding/json"
 	"strings"
 
+	"github.com/coreos/go-etcd/etcd"
 	"github.com/fsouza/go-dockerclient"
 )
 
@@ -12,44 +12,68 @@ type Register interface {
 	StartDockerEventLoop() chan struct{}
 	Add(id DockerContainerID) error
 	Delete(id DockerContainerID) error
+	Announced() (map[string]Announcement, error)
+	Stop()
+	Withdraw() error
 }
 
 type register struct {
 	dockerClient DockerInterface
 	etcdClient   EtcdInterface
+	checkers     *healthCheckers
+	// quit is closed to stop the docker event loop, which closes done.
+	quit chan struct{}
+	done chan struct{}
 }
 
 func NewRegister(dc DockerInterface, etcdc EtcdInterface) Register {
 	return &register{
 		dockerClient: dc,
 		etcdClient:   etcdc,
+		checkers:     newHealthCheckers(),
+		quit:         make(chan struct{}),
+		done:         make(chan struct{}),
 	}
 }
 
 func (r register) StartDockerEventLoop() chan struct{} {
 	c := make(chan *docker.APIEvents)
 	r.dockerClient.AddEventListener(c)
-	quit := make(chan struct{})
 
 	go func() {
-		defer close(quit)
-		for event := range c {
-			switch event.Status {
-			case "start":
-				r.Add(DockerContainerID(event.ID))
-			case "die":
-				r.Delete(DockerContainerID(event.ID))
+		defer close(r.done)
+		for {
+			select {
+			case <-r.quit:
+				r.dockerClient.RemoveEventListener(c)
+				return
+			case event, ok := <-c:
+				if !ok {
+					logger.Op("events").Warn("docker loop ended")
+					return
+				}
+				switch event.Status {
+				case "start":
+					r.Add(DockerContainerID(event.ID))
+				case "die":
+					r.Delete(DockerContainerID(event.ID))
+				}
 			}
 		}
-		log.Println("docker loop ended")
 	}()
-	return quit
+	return r.done
 }
 
 func (r register) Add(id DockerContainerID) error {
+	err := r.add(id)
+	registerAddTotal.Inc(resultOf(err))
+	return err
+}
+
+func (r register) add(id DockerContainerID) error {
 	container, err := r.dockerClient.InspectContainer(string(id))
 	if err != nil {
-		log.Println("register: ", err)
+		logger.Op("register").Error("inspecting container failed", "id", id, "err", err)
 		return err
 	}
 	if strings.HasPrefix(container.Name, "__") {
@@ -59,21 +83,50 @@ func (r register) Add(id DockerContainerID) error {
 	path := rootPath() + "containers/" + string(id)
 	_, err = r.etcdClient.Set(path, "", 0)
 
+	if hc := healthCheckOf(container); hc != nil {
+		// services are announced while the health check passes
+		r.checkers.start(id, func(stop chan struct{}) {
+			r.runHealthCheck(id, hc, stop)
+		})
+		return nil
+	}
+	return r.announce(container)
+}
+
+func (r register) announce(container *docker.Container) error {
 	services, err := Services(container)
 	if err != nil {
-		log.Println("register: ", err)
+		logForContainer(container.Name).Op("register").Error("getting services failed", "err", err)
 		return err
 	}
 	for _, s := range services {
 		err = s.Register(r.etcdClient)
 		if err != nil {
-			log.Println("register: ", err)
+			logForContainer(container.Name).Op("register").Error("announcing service failed", "err", err)
 		}
 	}
 	return nil
 }
 
+func (r register) withdraw(container *docker.Container) error {
+	services, err := Services(container)
+	for _, s := range services {
+		err = s.Delete(r.etcdClient)
+		if err != nil {
+			logForContainer(container.Name).Op("register").Error("withdrawing service failed", "err", err)
+		}
+	}
+	return err
+}
+
 func (r register) Delete(id DockerContainerID) error {
+	err := r.delete(id)
+	registerDeleteTotal.Inc(resultOf(err))
+	return err
+}
+
+func (r register) delete(id DockerContainerID) error {
+	r.checkers.stop(id)
 	path := rootPath() + "containers/" + string(id)
 	_, err := r.etcdClient.Delete(path, false)
 	if err != nil {
@@ -82,17 +135,51 @@ func (r register) Delete(id DockerContainerID) error {
 
 	container, err := r.dockerClient.InspectContainer(string(id))
 	if err != nil {
-		log.Println("register: ", err)
+		logger.Op("register").Error("inspecting container failed", "id", id, "err", err)
 		return err
 	}
-	services, err := Services(container)
-	for _, s := range services {
-		err = s.Delete(r.etcdClient)
-		if err != nil {
-			log.Println("register: ", err)
+	if r.replaced(container) {
+		// services are now served by the new container
+		return nil
+	}
+	return r.withdraw(container)
+}
+
+// replaced reports whether another running container has taken over the
+// name of the container, as happens in a rolling update.
+func (r register) replaced(container *docker.Container) bool {
+	name := strings.TrimPrefix(container.Name, "/")
+	current, err := r.dockerClient.InspectContainer(name)
+	return err == nil && current.ID != container.ID && current.State.Running
+}
+
+// Announced returns services announced to skydns for this host, keyed by
+// their skydns keys.
+func (r register) Announced() (map[string]Announcement, error) {
+	announced := map[string]Announcement{}
+	resp, err := r.etcdClient.Get("/skydns", false, true)
+	if err != nil {
+		if isKeyNotFound(err) {
+			return announced, nil
 		}
+		return nil, err
 	}
-	return err
+	var walk func(n *etcd.Node)
+	walk = func(n *etcd.Node) {
+		if n.Dir {
+			for _, nn := range n.Nodes {
+				walk(nn)
+			}
+			return
+		}
+		var ann Announcement
+		err := json.Unmarshal([]byte(n.Value), &ann)
+		if err == nil && ann.Host == hostIP {
+			announced[n.Key] = ann
+		}
+	}
+	walk(resp.Node)
+	return announced, nil
 }
 
 func rootPath() string {
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// Acquired returns manifests whose slots are held by this host.
+func (s scheduler) Acquired() ([]*Manifest, error) {
+	manifests, err := s.listManifests()
+	if err != nil {
+		return nil, err
+	}
+	var acquired []*Manifest
+	for _, m := range manifests {
+		if len(s.ownHostNodes(m)) > 0 {
+			acquired = append(acquired, m)
+		}
+	}
+	return acquired, nil
+}
+
+// Containers returns containers on this host which are managed by dokkaa.
+func (s scheduler) Containers() (map[string]docker.APIContainers, error) {
+	return s.managedContainers()
+}
+
+// Release gives up the slot of the container held by this host and removes
+// the container so that other hosts acquire the slot.
+func (s scheduler) Release(appName, containerName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	acquired, err := s.Acquired()
+	if err != nil {
+		return err
+	}
+	for _, m := range acquired {
+		if m.AppName == appName && m.ContainerName == containerName {
+			s.releaseContainer(m)
+			return nil
+		}
+	}
+	return fmt.Errorf("%s/%s is not acquired by this host", appName, containerName)
+}
+
+// ReleaseAll gives up all slots held by this host. Slots may be acquired by
+// this host again unless it is cordoned or drained.
+func (s scheduler) ReleaseAll() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.releaseAll()
+}
+
+func (s scheduler) releaseAll() error {
+	acquired, err := s.Acquired()
+	if err != nil {
+		return err
+	}
+	for _, m := range acquired {
+		s.releaseContainer(m)
+	}
+	return nil
+}
+
+// releaseContainer gives up the slot before removing the container so that
+// another host starts a replica as soon as possible.
+func (s scheduler) releaseContainer(m *Manifest) {
+	logFor(m).Op("release").Info("releasing slot")
+	s.release(m)
+	s.removeContainer(m)
+}
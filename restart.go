@@ -0,0 +1,134 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+const (
+	// crashLoopThreshold is the number of restarts in a row after which a
+	// container is reported as crash looping.
+	crashLoopThreshold = 3
+
+	restartBackoffBase = time.Second
+	restartBackoffMax  = 5 * time.Minute
+	// a container which ran longer than this is not considered crash looping
+	restartBackoffReset = 10 * time.Minute
+)
+
+func (p *RestartPolicy) shouldRestart(exitCode, restarts int) bool {
+	switch p.Policy {
+	case restartAlways:
+		return true
+	case restartOnFailure:
+		return exitCode != 0 && (p.MaxRetries == 0 || restarts < p.MaxRetries)
+	}
+	return false
+}
+
+// restartBackoff returns the delay before the n-th restart in a row.
+func restartBackoff(n int) time.Duration {
+	d := restartBackoffBase
+	for i := 0; i < n && d < restartBackoffMax; i++ {
+		d *= 2
+	}
+	if d > restartBackoffMax {
+		d = restartBackoffMax
+	}
+	return d
+}
+
+type restartState struct {
+	restarts int
+	pending  bool
+}
+
+// restartTracker counts restarts in a row of each container.
+type restartTracker struct {
+	mu     sync.Mutex
+	states map[string]*restartState
+}
+
+func newRestartTracker() *restartTracker {
+	return &restartTracker{
+		states: map[string]*restartState{},
+	}
+}
+
+func (t *restartTracker) pending(name string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st, ok := t.states[name]
+	return ok && st.pending
+}
+
+// next decides whether the container which has died should be restarted
+// and returns the number of restarts in a row including this one.
+func (t *restartTracker) next(name string, state docker.State, policy *RestartPolicy) (int, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st, ok := t.states[name]
+	if !ok {
+		st = &restartState{}
+		t.states[name] = st
+	}
+	if state.FinishedAt.Sub(state.StartedAt) >= restartBackoffReset {
+		st.restarts = 0
+	}
+	if !policy.shouldRestart(state.ExitCode, st.restarts) {
+		return st.restarts, false
+	}
+	st.restarts++
+	st.pending = true
+	return st.restarts, true
+}
+
+// reset forgets the restarts of the container, as a new one has been deployed.
+func (t *restartTracker) reset(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.states, name)
+}
+
+func (t *restartTracker) done(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if st, ok := t.states[name]; ok {
+		st.pending = false
+	}
+}
+
+// restart starts the dead container again unless it has been replaced or
+// this host has lost its slot in the meantime.
+func (s scheduler) restart(m *Manifest, id string, restarts int) {
+	name := m.Container.Name
+	defer s.restarts.done(name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped() {
+		return
+	}
+
+	current, err := s.dockerClient.InspectContainer(name)
+	if err != nil || current.ID != id || current.State.Running {
+		return
+	}
+	included, _ := s.hostsIncluded(m)
+	if !included {
+		return
+	}
+	err = s.dockerClient.StartContainer(id, nil)
+	if err != nil {
+		logFor(m).Op("restart").Error("restarting container failed", "err", err)
+		s.setHostStatus(m, failedHost(err))
+		return
+	}
+	logFor(m).Op("restart").Info("container restarted", "restarts", restarts)
+	s.setHostStatus(m, Host{
+		Addr:     hostIP,
+		Status:   hostStatusRunning,
+		Restarts: restarts,
+	})
+}
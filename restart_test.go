@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestShouldRestart(t *testing.T) {
+	always := &RestartPolicy{Policy: restartAlways}
+	if !always.shouldRestart(0, 100) {
+		t.Error(always)
+	}
+	never := &RestartPolicy{Policy: restartNever}
+	if never.shouldRestart(1, 0) {
+		t.Error(never)
+	}
+	onFailure := &RestartPolicy{Policy: restartOnFailure, MaxRetries: 2}
+	if onFailure.shouldRestart(0, 0) {
+		t.Error("must not restart on success")
+	}
+	if !onFailure.shouldRestart(1, 1) {
+		t.Error("must restart on failure")
+	}
+	if onFailure.shouldRestart(1, 2) {
+		t.Error("must not restart over MaxRetries")
+	}
+}
+
+func TestRestartBackoff(t *testing.T) {
+	expects := map[int]time.Duration{
+		0:  time.Second,
+		1:  2 * time.Second,
+		3:  8 * time.Second,
+		20: restartBackoffMax,
+	}
+	for n, d := range expects {
+		if restartBackoff(n) != d {
+			t.Error(n, restartBackoff(n))
+		}
+	}
+}
+
+func TestRestartTracker(t *testing.T) {
+	tracker := newRestartTracker()
+	policy := &RestartPolicy{Policy: restartOnFailure, MaxRetries: 2}
+	state := docker.State{ExitCode: 1}
+
+	n, ok := tracker.next("app---web", state, policy)
+	if !ok || n != 1 || !tracker.pending("app---web") {
+		t.Error(n, ok)
+	}
+	tracker.done("app---web")
+	n, ok = tracker.next("app---web", state, policy)
+	if !ok || n != 2 {
+		t.Error(n, ok)
+	}
+	tracker.done("app---web")
+	if _, ok = tracker.next("app---web", state, policy); ok {
+		t.Error("must give up after MaxRetries")
+	}
+
+	now := time.Now()
+	state.StartedAt = now.Add(-time.Hour)
+	state.FinishedAt = now
+	n, ok = tracker.next("app---web", state, policy)
+	if !ok || n != 1 {
+		t.Error("long running container must be restarted from the beginning", n, ok)
+	}
+}
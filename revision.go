@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+const (
+	revisionPending = "pending"
+	revisionGood    = "good"
+	revisionFailed  = "failed"
+
+	revisionHistoryLimit = 10
+)
+
+// Revision is an entry under /apps/<app>/<container>/revisions which keeps
+// a manifest value deployed in the past.
+type Revision struct {
+	Manifest string `json:"manifest"`
+	Status   string `json:"status"`
+	// Deployed is the etcd index of the manifest change which deployed the
+	// revision last.
+	Deployed uint64 `json:"deployed,omitempty"`
+}
+
+// deployedIndex returns the index the revision of the node was deployed at
+// last, or the index of the node for revisions recorded without it.
+func deployedIndex(n *etcd.Node) uint64 {
+	var rev Revision
+	json.Unmarshal([]byte(n.Value), &rev)
+	if rev.Deployed == 0 {
+		return n.CreatedIndex
+	}
+	return rev.Deployed
+}
+
+type byDeployed etcd.Nodes
+
+func (n byDeployed) Len() int           { return len(n) }
+func (n byDeployed) Swap(i, j int)      { n[i], n[j] = n[j], n[i] }
+func (n byDeployed) Less(i, j int) bool { return deployedIndex(n[i]) < deployedIndex(n[j]) }
+
+// recordRevision adds the manifest value set at index to the revision
+// history unless another host has already done it.
+func (s scheduler) recordRevision(m *Manifest, val string, index uint64) error {
+	value, _ := json.Marshal(Revision{
+		Manifest: val,
+		Status:   revisionPending,
+		Deployed: index,
+	})
+	_, err := s.etcdClient.Create(m.RevisionKey(), string(value), 0)
+	if isNodeExist(err) {
+		return s.redeployRevision(m, index)
+	}
+	if err != nil {
+		return err
+	}
+
+	nodes, err := s.listRevisions(m)
+	if err != nil {
+		return err
+	}
+	excess := len(nodes) - revisionHistoryLimit
+	for _, n := range nodes {
+		if excess <= 0 {
+			break
+		}
+		if n.Key == m.RevisionKey() {
+			// the deployed revision is never trimmed
+			continue
+		}
+		s.etcdClient.Delete(n.Key, false)
+		excess--
+	}
+	return nil
+}
+
+// redeployRevision records that an existing revision is deployed again by
+// the manifest change at index, and sets it back to pending if it had
+// failed, so that it is checked and rolled back again. Hosts handling an
+// older change, or one already recorded by another host, leave it alone.
+func (s scheduler) redeployRevision(m *Manifest, index uint64) error {
+	resp, err := s.etcdClient.Get(m.RevisionKey(), false, false)
+	if err != nil {
+		return err
+	}
+	var rev Revision
+	json.Unmarshal([]byte(resp.Node.Value), &rev)
+	if deployedIndex(resp.Node) >= index {
+		return nil
+	}
+	was := rev.Status
+	rev.Deployed = index
+	if rev.Status == revisionFailed {
+		rev.Status = revisionPending
+	}
+	value, _ := json.Marshal(rev)
+	_, err = s.etcdClient.CompareAndSwap(m.RevisionKey(), string(value), 0, "", resp.Node.ModifiedIndex)
+	if err != nil {
+		// another host has already done it
+		return nil
+	}
+	if was != rev.Status {
+		logFor(m).Op("revision").Info("revision is deployed again", "revision", m.Revision, "was", was)
+	}
+	return nil
+}
+
+// listRevisions returns revision nodes from the least to the most recently
+// deployed one.
+func (s scheduler) listRevisions(m *Manifest) (etcd.Nodes, error) {
+	resp, err := s.etcdClient.Get(m.RevisionsDirKey(), false, true)
+	if err != nil {
+		return nil, err
+	}
+	nodes := resp.Node.Nodes
+	sort.Sort(byDeployed(nodes))
+	return nodes, nil
+}
+
+func (s scheduler) setRevisionStatus(m *Manifest, rev Revision, status string) error {
+	rev.Status = status
+	value, _ := json.Marshal(rev)
+	_, err := s.etcdClient.Update(m.RevisionKey(), string(value), 0)
+	return err
+}
+
+// checkRevision marks the deploying revision good once all replicas are
+// running, or rolls back to the last good revision if too many replicas
+// failed.
+func (s scheduler) checkRevision(m *Manifest) {
+	resp, err := s.etcdClient.Get(m.RevisionKey(), false, false)
+	if err != nil {
+		return
+	}
+	var rev Revision
+	err = json.Unmarshal([]byte(resp.Node.Value), &rev)
+	if err != nil || rev.Status != revisionPending {
+		return
+	}
+
+	hosts, err := s.getHostEntries(m)
+	if err != nil {
+		return
+	}
+	running, failed := 0, 0
+	for _, h := range hosts {
+		if h.Revision != m.Revision {
+			continue
+		}
+		switch h.Status {
+		case hostStatusRunning:
+			running++
+		case hostStatusFailed, hostStatusCrashLoop:
+			failed++
+		}
+	}
+
+	scale := m.Container.Scale
+	if running >= scale {
+		logFor(m).Op("revision").Info("revision is good", "revision", m.Revision)
+		s.setRevisionStatus(m, rev, revisionGood)
+		return
+	}
+	policy := m.Container.Rollback
+	if policy != nil && float64(failed)/float64(scale) >= policy.FailureRatio {
+		s.setRevisionStatus(m, rev, revisionFailed)
+		s.rollback(m)
+	}
+}
+
+// rollback sets the manifest back to the last known-good revision.
+func (s scheduler) rollback(m *Manifest) error {
+	nodes, err := s.listRevisions(m)
+	if err != nil {
+		return err
+	}
+	target, ok := rollbackTarget(nodes, m.RevisionKey())
+	if !ok {
+		logFor(m).Op("rollback").Warn("no revision to roll back to", "revision", m.Revision)
+		return nil
+	}
+
+	resp, err := s.etcdClient.Get(m.ManifestKey(), false, false)
+	if err != nil {
+		return err
+	}
+	if revision(resp.Node.Value) != m.Revision {
+		// the manifest has already been changed
+		return nil
+	}
+	_, err = s.etcdClient.CompareAndSwap(m.ManifestKey(), target.Manifest, 0, resp.Node.Value, resp.Node.ModifiedIndex)
+	if err != nil {
+		return err
+	}
+	logFor(m).Op("rollback").Info("rolled back", "from", m.Revision, "to", revision(target.Manifest))
+	return nil
+}
+
+// rollbackTarget returns the most recently deployed good revision other
+// than current.
+func rollbackTarget(nodes etcd.Nodes, current string) (Revision, bool) {
+	var target Revision
+	var deployed uint64
+	found := false
+	for _, n := range nodes {
+		if n.Key == current {
+			continue
+		}
+		var rev Revision
+		err := json.Unmarshal([]byte(n.Value), &rev)
+		if err != nil || rev.Status != revisionGood {
+			continue
+		}
+		if !found || deployedIndex(n) >= deployed {
+			target, deployed, found = rev, deployedIndex(n), true
+		}
+	}
+	return target, found
+}
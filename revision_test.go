@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+func revisionNode(key, manifest, status string) *etcd.Node {
+	return deployedRevisionNode(key, manifest, status, 0)
+}
+
+func deployedRevisionNode(key, manifest, status string, deployed uint64) *etcd.Node {
+	value, _ := json.Marshal(Revision{Manifest: manifest, Status: status, Deployed: deployed})
+	return &etcd.Node{Key: key, Value: string(value)}
+}
+
+func TestRollbackTarget(t *testing.T) {
+	nodes := etcd.Nodes{
+		revisionNode("/r/1", "v1", revisionGood),
+		revisionNode("/r/2", "v2", revisionGood),
+		revisionNode("/r/3", "v3", revisionFailed),
+		revisionNode("/r/4", "v4", revisionPending),
+	}
+	rev, ok := rollbackTarget(nodes, "/r/4")
+	if !ok || rev.Manifest != "v2" {
+		t.Error(rev, ok)
+	}
+	rev, ok = rollbackTarget(nodes, "/r/2")
+	if !ok || rev.Manifest != "v1" {
+		t.Error(rev, ok)
+	}
+	_, ok = rollbackTarget(nodes[:1], "/r/1")
+	if ok {
+		t.Error("no revision to roll back to")
+	}
+
+	// v1 has been applied again after v2
+	nodes = etcd.Nodes{
+		deployedRevisionNode("/r/1", "v1", revisionGood, 9),
+		deployedRevisionNode("/r/2", "v2", revisionGood, 5),
+		deployedRevisionNode("/r/3", "v3", revisionPending, 12),
+	}
+	rev, ok = rollbackTarget(nodes, "/r/3")
+	if !ok || rev.Manifest != "v1" {
+		t.Error(rev, ok)
+	}
+}
+
+func TestRecordRevisionTrim(t *testing.T) {
+	e := newFakeEtcd()
+	s := NewScheduler(newFakeDocker(), e).(*scheduler)
+	first := `{"Image": "nginx:0"}`
+	m, _ := NewManifest("blog", "web", first)
+	set, _ := e.Set(m.ManifestKey(), first, 0)
+	s.recordRevision(m, first, set.Node.ModifiedIndex)
+	for i := 1; i < revisionHistoryLimit; i++ {
+		val := fmt.Sprintf(`{"Image": "nginx:%d"}`, i)
+		m, _ := NewManifest("blog", "web", val)
+		set, _ := e.Set(m.ManifestKey(), val, 0)
+		s.recordRevision(m, val, set.Node.ModifiedIndex)
+	}
+	// the first revision is applied again, then a new one
+	set, _ = e.Set(m.ManifestKey(), first, 0)
+	s.recordRevision(m, first, set.Node.ModifiedIndex)
+	val := `{"Image": "nginx:new"}`
+	latest, _ := NewManifest("blog", "web", val)
+	set, _ = e.Set(m.ManifestKey(), val, 0)
+	s.recordRevision(latest, val, set.Node.ModifiedIndex)
+
+	nodes, _ := s.listRevisions(m)
+	if len(nodes) != revisionHistoryLimit {
+		t.Fatal(len(nodes))
+	}
+	if nodes[len(nodes)-1].Key != latest.RevisionKey() || nodes[len(nodes)-2].Key != m.RevisionKey() {
+		t.Error("revisions must be ordered by deployment")
+	}
+	oldest, _ := NewManifest("blog", "web", `{"Image": "nginx:1"}`)
+	if e.value(oldest.RevisionKey()) != "" {
+		t.Error("the least recently deployed revision must be trimmed")
+	}
+}
+
+func TestRecordRevisionAgain(t *testing.T) {
+	e := newFakeEtcd()
+	s := NewScheduler(newFakeDocker(), e).(*scheduler)
+	val := `{"Image": "nginx:broken"}`
+	m, _ := NewManifest("blog", "web", val)
+	stored := func() Revision {
+		var rev Revision
+		json.Unmarshal([]byte(e.value(m.RevisionKey())), &rev)
+		return rev
+	}
+	status := func() string {
+		return stored().Status
+	}
+
+	set, _ := e.Set(m.ManifestKey(), val, 0)
+	s.recordRevision(m, val, set.Node.ModifiedIndex)
+	if status() != revisionPending {
+		t.Fatal(status())
+	}
+	s.setRevisionStatus(m, stored(), revisionFailed)
+
+	// a host handling the event late doesn't reset it
+	s.recordRevision(m, val, set.Node.ModifiedIndex)
+	if status() != revisionFailed {
+		t.Error(status())
+	}
+
+	// the failed value is applied again
+	set, _ = e.Set(m.ManifestKey(), val, 0)
+	s.recordRevision(m, val, set.Node.ModifiedIndex)
+	if status() != revisionPending {
+		t.Error(status())
+	}
+
+	s.setRevisionStatus(m, stored(), revisionGood)
+	set, _ = e.Set(m.ManifestKey(), val, 0)
+	s.recordRevision(m, val, set.Node.ModifiedIndex)
+	if status() != revisionGood {
+		t.Error(status())
+	}
+}
+
+func TestRollbackRedeploys(t *testing.T) {
+	defer func(ip string) { hostIP = ip }(hostIP)
+	hostIP = "10.0.0.1"
+
+	good := `{"Image": "nginx:1.9", "Rollback": {"FailureRatio": 0.5}}`
+	broken := `{"Image": "nginx:broken", "Rollback": {"FailureRatio": 0.5}}`
+	e := newFakeEtcd()
+	m1, _ := NewManifest("blog", "web", good)
+	m2, _ := NewManifest("blog", "web", broken)
+	d := newFakeDocker(runningContainer("blog---web", "DOKKAA_REVISION="+m2.Revision))
+	s := NewScheduler(d, e).(*scheduler)
+	e.Set("/hosts/10.0.0.1/alive", "", hostLeaseTTL)
+	set, _ := e.Set(m1.ManifestKey(), good, 0)
+	s.recordRevision(m1, good, set.Node.ModifiedIndex)
+	s.setRevisionStatus(m1, Revision{Manifest: good}, revisionGood)
+	set, _ = e.Set(m2.ManifestKey(), broken, 0)
+	s.recordRevision(m2, broken, set.Node.ModifiedIndex)
+	value, _ := json.Marshal(Host{Addr: hostIP, Status: hostStatusFailed, Revision: m2.Revision})
+	e.CreateInOrder(m2.HostsDirKey(), string(value), hostLeaseTTL)
+
+	e.record()
+	done := make(chan struct{})
+	go func() {
+		s.WatchAppChanges()
+		close(done)
+	}()
+	defer func() {
+		close(s.quit)
+		<-done
+	}()
+
+	s.mu.Lock()
+	s.checkRevision(m2)
+	s.mu.Unlock()
+	if v := e.value(m1.ManifestKey()); v != good {
+		t.Fatal("not rolled back:", v)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for s.runningRevision(m1) != m1.Revision {
+		if time.Now().After(deadline) {
+			t.Fatal("the good revision is not redeployed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
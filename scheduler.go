@@ -2,12 +2,11 @@ package main
 
 import (
 	"encoding/json"
-	"log"
 	"math"
 	"regexp"
 	"strconv"
 	"strings"
-	"time"
+	"sync"
 
 	"github.com/coreos/go-etcd/etcd"
 	"github.com/fsouza/go-dockerclient"
@@ -19,47 +18,85 @@ const (
 
 type Scheduler interface {
 	Schedule(ma *Manifest) error
+	Reconcile() error
 	StartSchedulingLoop() chan struct{}
+	Acquired() ([]*Manifest, error)
+	Containers() (map[string]docker.APIContainers, error)
+	Release(appName, containerName string) error
+	ReleaseAll() error
+	Cordon() error
+	Uncordon() error
+	Drain() error
+	Stop()
+	Leave() error
 }
 
 type scheduler struct {
 	dockerClient DockerInterface
 	etcdClient   EtcdInterface
+	secrets      SecretStore
+	mu           *sync.Mutex
+	restarts     *restartTracker
+	updates      *updateTracker
+	// quit is closed to stop the loops, which wg waits for.
+	quit chan struct{}
+	wg   *sync.WaitGroup
 }
 
 type manifestRunner struct {
 	manifest     *Manifest
 	dockerClient DockerInterface
+	secrets      SecretStore
 }
 
-func newManifestRunner(manifest *Manifest, dc DockerInterface) *manifestRunner {
+func newManifestRunner(manifest *Manifest, dc DockerInterface, secrets SecretStore) *manifestRunner {
 	return &manifestRunner{
 		manifest:     manifest,
 		dockerClient: dc,
+		secrets:      secrets,
 	}
 }
 
 func (mr manifestRunner) run() error {
 	container := mr.manifest.Container
-	opts := mr.buildRunOptions(mr.manifest.Container)
 	mr.dockerClient.RemoveContainer(docker.RemoveContainerOptions{
 		ID:    container.Name,
 		Force: true,
 	})
+	_, err := mr.start(container.Name)
+	return err
+}
+
+// start creates and starts a container of the manifest with the given name.
+func (mr manifestRunner) start(name string) (DockerContainerID, error) {
+	container := mr.manifest.Container
+	opts := mr.buildRunOptions(container)
+	opts.ContainerName = name
+	err := mr.injectSecrets(&opts)
+	if err != nil {
+		logFor(mr.manifest).Op("run").Error("injecting secrets failed", "err", err)
+		return "", err
+	}
 	runner := NewDockerRunner(mr.dockerClient)
 	containerID, err := runner.Run(container.Image, opts)
 	if err != nil {
-		log.Printf("error: %+v\n", err)
-		return err
+		logFor(mr.manifest).Op("run").Error("running container failed", "name", name, "err", err)
+		return "", err
 	}
-	log.Printf("%s is running: %s\n", container.Name, containerID)
-	return nil
+	logFor(mr.manifest).Op("run").Info("container is running", "name", name, "id", containerID)
+	return containerID, nil
 }
 
 func NewScheduler(dc DockerInterface, etcdc EtcdInterface) Scheduler {
 	return &scheduler{
 		dockerClient: dc,
 		etcdClient:   etcdc,
+		secrets:      NewSecretStore(etcdc, clusterKey),
+		mu:           &sync.Mutex{},
+		restarts:     newRestartTracker(),
+		updates:      newUpdateTracker(),
+		quit:         make(chan struct{}),
+		wg:           &sync.WaitGroup{},
 	}
 }
 
@@ -71,100 +108,188 @@ func (s scheduler) removeContainer(m *Manifest) error {
 	}
 	err = s.dockerClient.StopContainer(name, 60)
 	if err != nil {
-		log.Println(err)
+		logFor(m).Op("remove").Error("stopping container failed", "err", err)
 		return err
 	}
 	_, err = s.dockerClient.WaitContainer(name)
 	if err != nil {
-		log.Println(err)
+		logFor(m).Op("remove").Error("waiting container failed", "err", err)
 		return err
 	}
 	opts := docker.RemoveContainerOptions{
 		ID:            name,
-		RemoveVolumes: true,
+		RemoveVolumes: !m.Container.KeepVolumes,
 		Force:         false,
 	}
 	err = s.dockerClient.RemoveContainer(opts)
 	if err != nil {
-		log.Printf("error: %+v\n", err)
+		logFor(m).Op("remove").Error("removing container failed", "err", err)
 		return err
 	}
-
 	return nil
 }
 
 func (s scheduler) onManifestChanged(appName, containerName string, resp *etcd.Response) error {
-	action := resp.Action
-	val := resp.Node.Value
-	m, err := NewManifest(appName, containerName, val)
+	switch resp.Action {
+	// rollbacks and the scale command write the manifest by compareAndSwap
+	case "set", "create", "update", "compareAndSwap":
+		val := resp.Node.Value
+		m, err := NewManifest(appName, containerName, val)
+		if err == nil {
+			err = s.validate(m)
+		}
+		s.setManifestStatus(m, err)
+		if err != nil {
+			return err
+		}
+		s.recordRevision(m, val, resp.Node.ModifiedIndex)
+		return s.tryAcquire(m)
+	case "delete", "compareAndDelete", "expire":
+		// the deleted manifest is given only as the previous node
+		var m *Manifest
+		if resp.PrevNode != nil {
+			m, _ = NewManifest(appName, containerName, resp.PrevNode.Value)
+		}
+		if m == nil || m.Container == nil {
+			m = &Manifest{
+				AppName:       appName,
+				ContainerName: containerName,
+				Container:     &Container{Name: appName + "---" + containerName},
+			}
+		}
+		s.removeContainer(m)
+		s.release(m)
+	}
+	return nil
+}
+
+func (s scheduler) onHostsChanged(appName, containerName string, resp *etcd.Response) error {
+	switch resp.Action {
+	case "delete", "expire", "compareAndDelete":
+	default:
+		return nil
+	}
+
+	m, err := s.getManifest(appName, containerName)
 	if err != nil {
+		if isKeyNotFound(err) {
+			// the app itself has been deleted
+			return nil
+		}
 		return err
 	}
-	switch action {
-	case "set":
-		cls := NewCluster(s.etcdClient)
-		order, err := cls.HostLoadOrder(hostIP)
+	if m.Validate() != nil {
+		return nil
+	}
+	hosts, _ := s.getHosts(m)
+	if len(hosts) >= m.Container.Scale {
+		return nil
+	}
+	for _, h := range hosts {
+		if h == hostIP {
+			return nil
+		}
+	}
+	logFor(m).Op("acquire").Info("under replicated", "scale", m.Container.Scale, "hosts", hosts)
+	return s.tryAcquire(m)
+}
+
+// tryAcquire tries to acquire a slot of the manifest if the placement
+// strategy chooses this host, and runs its container if succeeded.
+func (s scheduler) tryAcquire(m *Manifest) error {
+	ok, _ := s.hostsIncluded(m)
+	if !ok {
+		chosen, err := s.chosen(m)
 		if err != nil {
-			log.Println(err)
+			logFor(m).Op("acquire").Error("choosing hosts failed", "err", err)
 			return err
 		}
-		time.Sleep(time.Second * time.Duration(order))
-		acquired, _ := s.acquire(m)
-		if acquired {
-			log.Printf("acquired: %+v\n", m)
-			s.Schedule(m)
-		} else {
-			s.removeContainer(m)
-			s.release(m)
+		ok = chosen
+	}
+	acquired := false
+	if ok {
+		acquired, _ = s.acquire(m)
+	}
+	if acquired {
+		logFor(m).Op("acquire").Info("acquired", "revision", m.Revision)
+		if m.Container.Sticky {
+			s.etcdClient.Set(m.StickyDirKey()+"/"+hostIP, "", 0)
+		}
+		if m.Container.Update.isRolling() {
+			s.startRollingUpdate(m)
+			return nil
 		}
-	case "delete":
+		s.Schedule(m)
+	} else {
 		s.removeContainer(m)
 		s.release(m)
 	}
 	return nil
 }
 
-func (s scheduler) onHostsChanged(appName, containerName string, node *etcd.Response) error {
-	// TODO
-	return nil
+func (s scheduler) getManifest(appName, containerName string) (*Manifest, error) {
+	m := &Manifest{
+		AppName:       appName,
+		ContainerName: containerName,
+	}
+	resp, err := s.etcdClient.Get(m.ManifestKey(), false, false)
+	if err != nil {
+		return nil, err
+	}
+	return NewManifest(appName, containerName, resp.Node.Value)
 }
 
 func (s scheduler) WatchAppChanges() {
 	watcher := NewEtcdWatcher(s.etcdClient)
-	recv := watcher.Watch("/apps", true)
+	recv := watcher.Watch("/apps", true, s.quit)
 	for n := range recv {
 		appName, containerName, file, err := keySubMatch(n.Node.Key)
 		if err != nil {
-			log.Println(err)
+			logger.Op("watch").Error("parsing key failed", "key", n.Node.Key, "err", err)
 			continue
 		}
 		err = nil
+		s.mu.Lock()
 		switch {
 		case file == "manifest":
 			err = s.onManifestChanged(appName, containerName, n)
 		case strings.HasPrefix(file, "hosts"):
 			err = s.onHostsChanged(appName, containerName, n)
 		}
+		s.mu.Unlock()
 		if err != nil {
-			log.Println(err)
+			logger.With("app", appName, "container", containerName).Op("watch").Error("handling change failed", "key", n.Node.Key, "action", n.Action, "err", err)
 			continue
 		}
 	}
 }
 
 func (s scheduler) StartSchedulingLoop() chan struct{} {
-	quit := make(chan struct{})
+	done := make(chan struct{})
 
+	s.wg.Add(5)
 	go func() {
-		defer close(quit)
+		defer s.wg.Done()
+		defer close(done)
 		s.WatchAppChanges()
 	}()
-	return quit
-}
-
-type Host struct {
-	Addr   string `json:"addr"`
-	Status string `json:"status"`
+	go func() {
+		defer s.wg.Done()
+		s.reconcileLoop()
+	}()
+	go func() {
+		defer s.wg.Done()
+		s.heartbeatLoop()
+	}()
+	go func() {
+		defer s.wg.Done()
+		s.watchContainerEvents()
+	}()
+	go func() {
+		defer s.wg.Done()
+		s.watchMode()
+	}()
+	return done
 }
 
 func keySubMatch(key string) (appName, containerName, file string, err error) {
@@ -177,19 +302,31 @@ func keySubMatch(key string) (appName, containerName, file string, err error) {
 }
 
 func (s scheduler) getHosts(manifest *Manifest) ([]string, error) {
+	entries, err := s.getHostEntries(manifest)
+	if err != nil {
+		return nil, err
+	}
+	var hosts []string
+	for _, h := range entries {
+		hosts = append(hosts, h.Addr)
+	}
+	return hosts, nil
+}
+
+func (s scheduler) getHostEntries(manifest *Manifest) ([]Host, error) {
 	key := manifest.HostsDirKey()
 	resp, err := s.etcdClient.Get(key, true, true)
 	if err != nil {
-		log.Println(err)
+		logFor(manifest).Debug("getting hosts failed", "err", err)
 		return nil, err
 	}
-	var hosts []string
+	var hosts []Host
 	for _, v := range resp.Node.Nodes {
 		var h Host
 		json.Unmarshal([]byte(v.Value), &h)
-		hosts = append(hosts, h.Addr)
+		hosts = append(hosts, h)
 	}
-	return hosts, err
+	return hosts, nil
 }
 
 func (s scheduler) hostsIncluded(manifest *Manifest) (bool, error) {
@@ -212,12 +349,33 @@ func (s scheduler) hostsIncluded(manifest *Manifest) (bool, error) {
 	}
 	limitExceeded := !included && len(hosts) >= scale
 	if limitExceeded {
-		log.Println("already acquired by other hosts. scale=", manifest.Container.Scale, " hosts=", hosts)
+		logFor(manifest).Op("acquire").Debug("already acquired by other hosts", "scale", manifest.Container.Scale, "hosts", hosts)
 	}
 	return included, nil
 }
 
 func (s scheduler) acquire(manifest *Manifest) (bool, error) {
+	// check if this host can run the manifest
+	cs, err := s.clusterState()
+	if err != nil {
+		logFor(manifest).Op("acquire").Error("getting cluster state failed", "err", err)
+		acquireTotal.Inc("error")
+		return false, err
+	}
+	if !cs.schedulable(hostIP) {
+		// cordoned hosts keep the slots they hold
+		included, _ := s.hostsIncluded(manifest)
+		if !included {
+			acquireTotal.Inc("unschedulable")
+		}
+		return included, nil
+	}
+	if !cs.eligible(manifest, hostIP) {
+		logFor(manifest).Op("acquire").Info("this host doesn't satisfy the constraints")
+		acquireTotal.Inc("ineligible")
+		return false, nil
+	}
+
 	// check if this host is already included
 	included, err := s.hostsIncluded(manifest)
 	if included {
@@ -226,54 +384,62 @@ func (s scheduler) acquire(manifest *Manifest) (bool, error) {
 
 	// Set Host
 	hs, err := json.Marshal(Host{
-		Addr:   hostIP,
-		Status: "creating",
+		Addr:     hostIP,
+		Status:   hostStatusPulling,
+		Revision: manifest.Revision,
 	})
 	if err != nil {
-		log.Println(err)
+		logFor(manifest).Op("acquire").Error("encoding host failed", "err", err)
+		acquireTotal.Inc("error")
 		return false, err
 	}
-	_, err = s.etcdClient.CreateInOrder(manifest.HostsDirKey(), string(hs), 0)
+	_, err = s.etcdClient.CreateInOrder(manifest.HostsDirKey(), string(hs), hostLeaseTTL)
 	if err != nil {
-		log.Println(err.(*etcd.EtcdError))
+		logFor(manifest).Op("acquire").Error("creating host entry failed", "err", err)
+		acquireTotal.Inc("error")
 		return false, err
 	}
 
 	// Check acquired order exceeds scale limit
 	included, err = s.hostsIncluded(manifest)
+	if included {
+		acquireTotal.Inc("acquired")
+	} else {
+		// other hosts acquired the slots first
+		acquireTotal.Inc("lost")
+	}
 	return included, err
 }
 
 func (s scheduler) Schedule(ma *Manifest) error {
+	s.setHostStatus(ma, Host{Addr: hostIP, Status: hostStatusPulling})
 	image := ma.Container.Image
 	err := s.pullImage(image)
 	if err != nil {
-		log.Printf("error: %+v\n", err)
+		logFor(ma).Op("pull").Error("pulling image failed", "image", image, "err", err)
+		s.setHostStatus(ma, failedHost(err))
+		scheduleTotal.Inc(hostStatusFailed)
 		return err
 	}
 
-	mr := newManifestRunner(ma, s.dockerClient)
-	mr.run()
+	s.setHostStatus(ma, Host{Addr: hostIP, Status: hostStatusCreating})
+	mr := newManifestRunner(ma, s.dockerClient, s.secrets)
+	err = mr.run()
+	if err != nil {
+		s.setHostStatus(ma, failedHost(err))
+		scheduleTotal.Inc(hostStatusFailed)
+		return err
+	}
+	s.restarts.reset(ma.Container.Name)
+	s.setHostStatus(ma, Host{Addr: hostIP, Status: hostStatusRunning})
+	scheduleTotal.Inc(hostStatusRunning)
 
 	return nil
 }
 
 func (s scheduler) release(manifest *Manifest) error {
-	key := manifest.HostsDirKey()
-	resp, err := s.etcdClient.Get(key, true, true)
-	if err != nil {
-		log.Println(err)
-		return err
-	}
-	for _, n := range resp.Node.Nodes {
-		var h Host
-		err = json.Unmarshal([]byte(n.Value), &h)
-		if err != nil {
-			continue
-		}
-		if h.Addr == hostIP {
-			s.etcdClient.Delete(n.Key, false)
-		}
+	for _, n := range s.ownHostNodes(manifest) {
+		s.etcdClient.Delete(n.Key, false)
 	}
 	return nil
 }
@@ -286,16 +452,27 @@ func (s scheduler) pullImage(image string) error {
 func (mr manifestRunner) buildRunOptions(container *Container) DockerRunOptions {
 	name := container.Name
 	env := buildEnv(container.Env)
-	var ports []int
+	var services []Srv
 	for _, s := range container.Services {
-		ports = append(ports, s.Port)
+		services = append(services, s)
+	}
+	exposedPorts := buildExposedPorts(services)
+	portBindings := buildPortBindings(container.Ports)
+	for p := range portBindings {
+		exposedPorts[p] = struct{}{}
 	}
-	exposedPorts := buildExposedPorts(ports)
 	var links []string
 	c, _ := mr.dockerClient.InspectContainer(ambassadorName)
 	if c != nil {
 		links = append(links, ambassadorName+":backends")
 	}
+	hostConfig := &docker.HostConfig{
+		PublishAllPorts: true,
+		PortBindings:    portBindings,
+		Links:           links,
+		Mounts:          buildMounts(container.Volumes),
+	}
+	container.Limits.apply(hostConfig)
 	return DockerRunOptions{
 		ContainerName: name,
 		ContainerConfig: &docker.Config{
@@ -303,10 +480,23 @@ func (mr manifestRunner) buildRunOptions(container *Container) DockerRunOptions
 			ExposedPorts: exposedPorts,
 			Cmd:          container.Command,
 		},
-		HostConfig: &docker.HostConfig{
-			PublishAllPorts: true,
-			Links:           links,
-		},
+		HostConfig: hostConfig,
+	}
+}
+
+func (l *Limits) apply(hostConfig *docker.HostConfig) {
+	if l == nil {
+		return
+	}
+	hostConfig.Memory = l.Memory
+	hostConfig.MemorySwap = l.MemorySwap
+	hostConfig.CPUShares = l.CPUShares
+	hostConfig.CPUQuota = l.CPUQuota
+	hostConfig.CPUPeriod = l.CPUPeriod
+	hostConfig.CPUSetCPUs = l.Cpuset
+	if l.PidsLimit > 0 {
+		pids := l.PidsLimit
+		hostConfig.PidsLimit = &pids
 	}
 }
 
@@ -319,11 +509,45 @@ func buildEnv(env map[string]string) []string {
 	return res
 }
 
-func buildExposedPorts(ports []int) map[docker.Port]struct{} {
+func buildExposedPorts(services []Srv) map[docker.Port]struct{} {
 	exposedPorts := map[docker.Port]struct{}{}
-	for _, p := range ports {
-		exposedPorts[docker.Port(strconv.Itoa(p)+"/tcp")] = struct{}{}
+	for _, s := range services {
+		proto := s.Protocol
+		if proto == "" {
+			proto = "tcp"
+		}
+		exposedPorts[docker.Port(strconv.Itoa(s.Port)+"/"+proto)] = struct{}{}
 	}
 
 	return exposedPorts
 }
+
+func buildPortBindings(ports []Port) map[docker.Port][]docker.PortBinding {
+	bindings := map[docker.Port][]docker.PortBinding{}
+	for _, p := range ports {
+		port := docker.Port(strconv.Itoa(p.ContainerPort) + "/" + p.Protocol)
+		bindings[port] = append(bindings[port], docker.PortBinding{
+			HostIP:   p.HostIP,
+			HostPort: strconv.Itoa(p.HostPort),
+		})
+	}
+
+	return bindings
+}
+
+func buildMounts(volumes []Volume) []docker.HostMount {
+	var mounts []docker.HostMount
+	for _, v := range volumes {
+		mount := docker.HostMount{
+			Type:     v.Type,
+			Target:   v.Target,
+			ReadOnly: v.ReadOnly,
+		}
+		if v.Type != volumeTypeTmpfs {
+			mount.Source = v.Source
+		}
+		mounts = append(mounts, mount)
+	}
+
+	return mounts
+}
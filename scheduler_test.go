@@ -1 +1,140 @@
 package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestExitedHost(t *testing.T) {
+	h := exitedHost(docker.State{ExitCode: 0})
+	if h.Status != hostStatusExited {
+		t.Error(h)
+	}
+	h = exitedHost(docker.State{ExitCode: 2})
+	if h.Status != hostStatusFailed || h.ExitCode != 2 || h.Error == "" {
+		t.Error(h)
+	}
+}
+
+func TestLimitsApply(t *testing.T) {
+	m, err := NewManifest("app", "web", `{"Limits": {"Memory": 536870912, "MemorySwap": -1, "CPUShares": 512, "CPUQuota": 50000, "CPUPeriod": 100000, "Cpuset": "0,1", "PidsLimit": 100}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hc := &docker.HostConfig{}
+	m.Container.Limits.apply(hc)
+	if hc.Memory != 536870912 || hc.MemorySwap != -1 || hc.CPUShares != 512 ||
+		hc.CPUQuota != 50000 || hc.CPUPeriod != 100000 || hc.CPUSetCPUs != "0,1" {
+		t.Error(hc)
+	}
+	if hc.PidsLimit == nil || *hc.PidsLimit != 100 {
+		t.Error(hc.PidsLimit)
+	}
+
+	hc = &docker.HostConfig{}
+	var limits *Limits
+	limits.apply(hc)
+	if hc.Memory != 0 || hc.PidsLimit != nil {
+		t.Error(hc)
+	}
+}
+
+func TestBuildPortBindings(t *testing.T) {
+	bindings := buildPortBindings([]Port{
+		{HostPort: 80, ContainerPort: 8080, Protocol: "tcp"},
+		{HostPort: 53, ContainerPort: 53, Protocol: "udp", HostIP: "10.0.0.1"},
+	})
+	b := bindings[docker.Port("8080/tcp")]
+	if len(b) != 1 || b[0].HostPort != "80" || b[0].HostIP != "" {
+		t.Error(bindings)
+	}
+	b = bindings[docker.Port("53/udp")]
+	if len(b) != 1 || b[0].HostPort != "53" || b[0].HostIP != "10.0.0.1" {
+		t.Error(bindings)
+	}
+}
+
+func TestBuildMounts(t *testing.T) {
+	m, err := NewManifest("app", "db", `{"Volumes": [
+		{"Source": "pgdata", "Target": "/var/lib/postgresql/data"},
+		{"Type": "bind", "Source": "/etc/ssl", "Target": "/etc/ssl", "ReadOnly": true},
+		{"Type": "tmpfs", "Source": "ignored", "Target": "/tmp"}
+	]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mounts := buildMounts(m.Container.Volumes)
+	expected := []docker.HostMount{
+		{Type: "volume", Source: "pgdata", Target: "/var/lib/postgresql/data"},
+		{Type: "bind", Source: "/etc/ssl", Target: "/etc/ssl", ReadOnly: true},
+		{Type: "tmpfs", Target: "/tmp"},
+	}
+	if !reflect.DeepEqual(mounts, expected) {
+		t.Error(mounts)
+	}
+}
+
+func TestOnHostsChanged(t *testing.T) {
+	defer func(ip string) { hostIP = ip }(hostIP)
+	hostIP = "10.0.0.1"
+
+	e := newFakeEtcd()
+	e.Set("/hosts/10.0.0.1/alive", "", hostLeaseTTL)
+	e.Set("/apps/blog/web/manifest", `{"Image": "nginx", "Scale": 2}`, 0)
+	e.CreateInOrder("/apps/blog/web/hosts", `{"Addr": "10.0.0.2", "Status": "running"}`, hostLeaseTTL)
+	d := newFakeDocker()
+	s := NewScheduler(d, e).(*scheduler)
+
+	// only deletions of host entries are handled
+	s.onHostsChanged("blog", "web", &etcd.Response{Action: "set"})
+	if _, err := d.InspectContainer("blog---web"); err == nil {
+		t.Fatal("must not be scheduled")
+	}
+
+	err := s.onHostsChanged("blog", "web", &etcd.Response{Action: "expire"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := d.InspectContainer("blog---web")
+	if err != nil || !c.State.Running {
+		t.Fatal("must be running", err)
+	}
+	hosts, _ := s.getHosts(&Manifest{AppName: "blog", ContainerName: "web"})
+	if !reflect.DeepEqual(hosts, []string{"10.0.0.2", "10.0.0.1"}) {
+		t.Error(hosts)
+	}
+
+	// a replica left, but the scale is still satisfied
+	hostIP = "10.0.0.3"
+	s.onHostsChanged("blog", "web", &etcd.Response{Action: "delete"})
+	hosts, _ = s.getHosts(&Manifest{AppName: "blog", ContainerName: "web"})
+	if len(hosts) != 2 {
+		t.Error(hosts)
+	}
+}
+
+func TestRemoveContainerSticky(t *testing.T) {
+	defer func(ip string) { hostIP = ip }(hostIP)
+	hostIP = "10.0.0.1"
+
+	e := newFakeEtcd()
+	d := newFakeDocker(runningContainer("blog---db"))
+	s := NewScheduler(d, e).(*scheduler)
+	m, _ := NewManifest("blog", "db", `{"Image": "postgres", "Volumes": [{"Source": "pgdata", "Target": "/var/lib/postgresql/data"}], "Sticky": true}`)
+	e.Set(m.StickyDirKey()+"/"+hostIP, "", 0)
+
+	err := s.removeContainer(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(d.removed, []string{"blog---db"}) {
+		t.Error(d.removed)
+	}
+	// the named volume still holds the data on this host
+	if _, err := e.Get(m.StickyDirKey()+"/"+hostIP, false, false); err != nil {
+		t.Error("sticky record must be kept", err)
+	}
+}
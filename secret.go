@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+var (
+	// clusterKey encrypts secrets under /secrets. It is shared by all
+	// conductors and clients of the cluster.
+	clusterKey []byte
+	// secretsDir is the directory on the host where secret files are
+	// written to be bind-mounted into containers.
+	secretsDir = "/var/lib/dokkaa/secrets"
+)
+
+// parseClusterKey decodes a base64 encoded AES-128, 192 or 256 key.
+func parseClusterKey(s string) ([]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	}
+	return nil, errors.New("cluster key must be 16, 24 or 32 bytes")
+}
+
+type SecretStore interface {
+	Get(app, name string) (string, error)
+	Set(app, name, value string) error
+	Delete(app, name string) error
+}
+
+type secretStore struct {
+	client EtcdInterface
+	key    []byte
+}
+
+// NewSecretStore returns a store of secrets encrypted with the key under
+// /secrets/<app>/<name>.
+func NewSecretStore(cli EtcdInterface, key []byte) SecretStore {
+	return &secretStore{
+		client: cli,
+		key:    key,
+	}
+}
+
+func secretKey(app, name string) string {
+	return "/secrets/" + app + "/" + name
+}
+
+func (ss *secretStore) Get(app, name string) (string, error) {
+	if ss.key == nil {
+		return "", errors.New("secret: cluster key is not set")
+	}
+	resp, err := ss.client.Get(secretKey(app, name), false, false)
+	if err != nil {
+		if isKeyNotFound(err) {
+			return "", fmt.Errorf("secret: %s of %s not found", name, app)
+		}
+		return "", err
+	}
+	value, err := decrypt(ss.key, resp.Node.Value)
+	if err != nil {
+		return "", fmt.Errorf("secret: failed to decrypt %s of %s", name, app)
+	}
+	return value, nil
+}
+
+func (ss *secretStore) Set(app, name, value string) error {
+	if ss.key == nil {
+		return errors.New("secret: cluster key is not set")
+	}
+	encrypted, err := encrypt(ss.key, value)
+	if err != nil {
+		return err
+	}
+	_, err = ss.client.Set(secretKey(app, name), encrypted, 0)
+	return err
+}
+
+func (ss *secretStore) Delete(app, name string) error {
+	_, err := ss.client.Delete(secretKey(app, name), false)
+	return err
+}
+
+// encrypt seals plaintext with AES-GCM and returns base64 of nonce and
+// ciphertext.
+func encrypt(key []byte, plaintext string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func decrypt(key []byte, encrypted string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("secret: malformed value")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// injectSecrets adds secrets referred by the manifest to the run options
+// as env variables or read-only files. Values are never logged.
+func (mr manifestRunner) injectSecrets(opts *DockerRunOptions) error {
+	m := mr.manifest
+	for _, ref := range m.Container.Secrets {
+		value, err := mr.secrets.Get(m.AppName, ref.Name)
+		if err != nil {
+			return err
+		}
+		if ref.Env != "" {
+			if _, ok := m.Container.Env[ref.Env]; ok {
+				return fmt.Errorf("secret: env %s of %s conflicts with another variable", ref.Env, ref.Name)
+			}
+			opts.ContainerConfig.Env = append(opts.ContainerConfig.Env, ref.Env+"="+value)
+		}
+		if ref.File != "" {
+			source, err := writeSecretFile(m, ref.Name, value)
+			if err != nil {
+				return err
+			}
+			opts.HostConfig.Mounts = append(opts.HostConfig.Mounts, docker.HostMount{
+				Type:     volumeTypeBind,
+				Source:   source,
+				Target:   ref.File,
+				ReadOnly: true,
+			})
+		}
+	}
+	return nil
+}
+
+func writeSecretFile(m *Manifest, name, value string) (string, error) {
+	dir := filepath.Join(secretsDir, m.AppName, m.ContainerName)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, name)
+	err = ioutil.WriteFile(path, []byte(value), 0400)
+	if os.IsPermission(err) {
+		// the file exists from a previous deployment
+		os.Remove(path)
+		err = ioutil.WriteFile(path, []byte(value), 0400)
+	}
+	return path, err
+}
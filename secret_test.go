@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+type secretStoreMock map[string]string
+
+func (ss secretStoreMock) Get(app, name string) (string, error) {
+	return ss[app+"/"+name], nil
+}
+
+func (ss secretStoreMock) Set(app, name, value string) error {
+	ss[app+"/"+name] = value
+	return nil
+}
+
+func (ss secretStoreMock) Delete(app, name string) error {
+	delete(ss, app+"/"+name)
+	return nil
+}
+
+func TestEncrypt(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	encrypted, err := encrypt(key, "p@ssw0rd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encrypted == "p@ssw0rd" {
+		t.Error("value must be encrypted")
+	}
+	value, err := decrypt(key, encrypted)
+	if err != nil || value != "p@ssw0rd" {
+		t.Error(value, err)
+	}
+	if _, err := decrypt([]byte("fedcba9876543210fedcba9876543210"), encrypted); err == nil {
+		t.Error("decrypting with another key must fail")
+	}
+}
+
+func TestParseClusterKey(t *testing.T) {
+	key, err := parseClusterKey(base64.StdEncoding.EncodeToString([]byte("0123456789abcdef")))
+	if err != nil || len(key) != 16 {
+		t.Error(key, err)
+	}
+	if _, err := parseClusterKey(base64.StdEncoding.EncodeToString([]byte("short"))); err == nil {
+		t.Error("short key must be an error")
+	}
+	if key, err := parseClusterKey(""); key != nil || err != nil {
+		t.Error(key, err)
+	}
+}
+
+func TestInjectSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	secretsDir = dir
+
+	m, err := NewManifest("app", "web", `{"Secrets": [{"Name": "db", "Env": "DB_PASSWORD"}, {"Name": "tls", "File": "/etc/tls.key"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets := secretStoreMock{"app/db": "p@ssw0rd", "app/tls": "KEY"}
+	mr := newManifestRunner(m, &dockerMock{}, secrets)
+	opts := DockerRunOptions{
+		ContainerConfig: &docker.Config{},
+		HostConfig:      &docker.HostConfig{},
+	}
+	err = mr.injectSecrets(&opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(opts.ContainerConfig.Env) != 1 || opts.ContainerConfig.Env[0] != "DB_PASSWORD=p@ssw0rd" {
+		t.Error(opts.ContainerConfig.Env)
+	}
+	mounts := opts.HostConfig.Mounts
+	if len(mounts) != 1 || mounts[0].Target != "/etc/tls.key" || !mounts[0].ReadOnly {
+		t.Fatal(mounts)
+	}
+	content, err := ioutil.ReadFile(mounts[0].Source)
+	if err != nil || string(content) != "KEY" {
+		t.Error(string(content), err)
+	}
+	if m.Container.Env["DB_PASSWORD"] != "" {
+		t.Error("secrets must not be kept in the manifest")
+	}
+
+	// redeploy overwrites the read-only file
+	secrets["app/tls"] = "NEW KEY"
+	err = mr.injectSecrets(&opts)
+	content, _ = ioutil.ReadFile(mounts[0].Source)
+	if err != nil || string(content) != "NEW KEY" {
+		t.Error(string(content), err)
+	}
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"encoding/json"
-	"log"
 	"path"
 	"strconv"
 	"strings"
@@ -34,6 +33,7 @@ type Announcement struct {
 func Services(container *docker.Container) ([]Service, error) {
 	serviceMap := map[string]string{}
 	roleMap := map[string]string{}
+	protoMap := map[string]string{}
 
 	var appName string
 	for _, e := range container.Config.Env {
@@ -52,23 +52,29 @@ func Services(container *docker.Container) ([]Service, error) {
 			name = strings.ToLower(name)
 			roleMap[name] = parts[1]
 		}
+		if strings.HasPrefix(parts[0], "DOKKAA_PROTOCOL_") {
+			name := strings.TrimPrefix(parts[0], "DOKKAA_PROTOCOL_")
+			name = strings.ToLower(name)
+			protoMap[name] = parts[1]
+		}
 	}
 
 	services := []Service{}
 	for name, port := range serviceMap {
-		portBinding, ok := container.NetworkSettings.Ports[docker.Port(port+"/tcp")]
+		proto, ok := protoMap[name]
 		if !ok {
-			log.Println("No port binding for ", port+"/tcp")
+			proto = "tcp"
+		}
+		portBinding, ok := container.NetworkSettings.Ports[docker.Port(port+"/"+proto)]
+		if !ok || len(portBinding) == 0 {
+			logForContainer(container.Name).Op("register").Warn("no port binding", "port", port+"/"+proto)
 			continue
 		}
-		hostPort := portBinding[0].HostPort
-		hostPort = strings.TrimSuffix(hostPort, "/tcp")
-		hostPort = strings.TrimSuffix(hostPort, "/udp")
 		s := &service{
 			App:      appName,
 			Name:     name,
 			Port:     port,
-			HostPort: hostPort,
+			HostPort: portBinding[0].HostPort,
 			Role:     roleMap[name],
 		}
 		services = append(services, s)
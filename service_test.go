@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestServices(t *testing.T) {
+	container := &docker.Container{
+		Config: &docker.Config{
+			Env: []string{
+				"DOKKAA_APP_NAME=app",
+				"DOKKAA_SERVICE_http=80",
+				"DOKKAA_ROLE_http=web",
+				"DOKKAA_SERVICE_dns=53",
+				"DOKKAA_PROTOCOL_dns=udp",
+				"DOKKAA_SERVICE_missing=8080",
+			},
+		},
+		NetworkSettings: &docker.NetworkSettings{
+			Ports: map[docker.Port][]docker.PortBinding{
+				"80/tcp": []docker.PortBinding{docker.PortBinding{HostPort: "49153"}},
+				"53/tcp": []docker.PortBinding{docker.PortBinding{HostPort: "49154"}},
+				"53/udp": []docker.PortBinding{docker.PortBinding{HostPort: "49155"}},
+			},
+		},
+	}
+	services, err := Services(container)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 2 {
+		t.Fatal(services)
+	}
+	for _, s := range services {
+		srv := s.(*service)
+		switch srv.Name {
+		case "http":
+			if srv.HostPort != "49153" || srv.Role != "web" || srv.App != "app" {
+				t.Error(srv)
+			}
+		case "dns":
+			if srv.HostPort != "49155" {
+				t.Error(srv)
+			}
+		default:
+			t.Error(srv)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// releaseOnShutdown is set by RELEASE_ON_SHUTDOWN to hand the slots and
+// services of this host over to other hosts on shutdown.
+var releaseOnShutdown = false
+
+// shutdownSignals returns a channel receiving SIGTERM and SIGINT.
+func shutdownSignals() chan os.Signal {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGTERM, syscall.SIGINT)
+	return c
+}
+
+// shutdown stops the loops of the scheduler and the register after their
+// in-flight operations, and releases slots and services of this host if
+// release is set.
+func shutdown(s Scheduler, r Register, release bool) {
+	logger.Op("shutdown").Info("stopping")
+	s.Stop()
+	r.Stop()
+	if !release {
+		logger.Op("shutdown").Info("stopped")
+		return
+	}
+	err := s.Leave()
+	if err != nil {
+		logger.Op("shutdown").Error("releasing slots failed", "err", err)
+	}
+	err = r.Withdraw()
+	if err != nil {
+		logger.Op("shutdown").Error("withdrawing services failed", "err", err)
+	}
+	logger.Op("shutdown").Info("stopped", "released", true)
+}
+
+// Stop stops the scheduling loops and waits for operations in progress.
+func (s scheduler) Stop() {
+	close(s.quit)
+	s.wg.Wait()
+	// wait for a restart or an API call holding the lock
+	s.mu.Lock()
+	s.mu.Unlock()
+}
+
+func (s scheduler) stopped() bool {
+	select {
+	case <-s.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// Leave tells other hosts that this host is gone and releases all slots
+// it holds so that they acquire them.
+func (s scheduler) Leave() error {
+	_, err := s.etcdClient.Delete(rootPath()+"alive", false)
+	if err != nil && !isKeyNotFound(err) {
+		return err
+	}
+	return s.ReleaseAll()
+}
+
+// Stop stops listening docker events and health checks.
+func (r register) Stop() {
+	close(r.quit)
+	<-r.done
+	r.checkers.stopAll()
+}
+
+// Withdraw deletes all services and containers this host has registered.
+func (r register) Withdraw() error {
+	announced, err := r.Announced()
+	if err != nil {
+		return err
+	}
+	for key := range announced {
+		_, err = r.etcdClient.Delete(key, false)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = r.etcdClient.Delete(rootPath()+"containers", true)
+	if err != nil && !isKeyNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/fsouza/go-dockerclient"
+)
+
+const (
+	surgeSuffix = "---next"
+)
+
+// updatePollInterval is the interval of polling update slots and
+// readiness of new containers.
+var updatePollInterval = time.Second
+
+// errUpdateAborted is returned if the conductor is stopping or this host
+// has lost the slot of the manifest while updating.
+var errUpdateAborted = errors.New("update aborted")
+
+// updateTracker serialises rolling updates of each container. A manifest
+// set while its container is being updated is queued, replacing any older
+// queued one, and deployed after the update in progress.
+type updateTracker struct {
+	mu sync.Mutex
+	// queued maps a container name to the manifest to deploy next, or to
+	// nil if nothing is queued behind the update in progress
+	queued map[string]*Manifest
+}
+
+func newUpdateTracker() *updateTracker {
+	return &updateTracker{
+		queued: map[string]*Manifest{},
+	}
+}
+
+// queue queues the manifest and reports whether no update of the
+// container is in progress.
+func (t *updateTracker) queue(m *Manifest) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, updating := t.queued[m.Container.Name]
+	t.queued[m.Container.Name] = m
+	return !updating
+}
+
+// next returns the queued manifest of the container, or false when the
+// updates of the container are done.
+func (t *updateTracker) next(name string) (*Manifest, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m := t.queued[name]
+	if m == nil {
+		delete(t.queued, name)
+		return nil, false
+	}
+	t.queued[name] = nil
+	return m, true
+}
+
+func (t *updateTracker) updating(name string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.queued[name]
+	return ok
+}
+
+// startRollingUpdate runs the rolling update of the manifest in the
+// background, since it waits for other hosts and for the new container.
+func (s scheduler) startRollingUpdate(m *Manifest) {
+	if s.stopped() || !s.updates.queue(m) {
+		return
+	}
+	name := m.Container.Name
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for m, ok := s.updates.next(name); ok; m, ok = s.updates.next(name) {
+			if s.stopped() {
+				continue
+			}
+			s.rollingUpdate(m)
+		}
+	}()
+}
+
+// rollingUpdate replaces the container of this host with a new revision
+// once this host gets one of the update slots of the manifest. The lock is
+// held only while containers are replaced, not while waiting.
+func (s scheduler) rollingUpdate(m *Manifest) error {
+	s.mu.Lock()
+	if s.runningRevision(m) == m.Revision {
+		defer s.mu.Unlock()
+		return s.setHostStatus(m, Host{Addr: hostIP, Status: hostStatusRunning})
+	}
+	if !s.isRunning(m) {
+		defer s.mu.Unlock()
+		// nothing to keep available
+		return s.Schedule(m)
+	}
+	s.mu.Unlock()
+
+	s.setHostStatus(m, Host{Addr: hostIP, Status: hostStatusPulling})
+	err := s.pullImage(m.Container.Image)
+	if err != nil {
+		logFor(m).Op("update").Error("pulling image failed", "image", m.Container.Image, "err", err)
+		s.setHostStatus(m, failedHost(err))
+		return err
+	}
+
+	slot, err := s.createUpdateSlot(m)
+	if err != nil {
+		logFor(m).Op("update").Error("creating update slot failed", "err", err)
+		s.setHostStatus(m, failedHost(err))
+		return err
+	}
+	defer s.etcdClient.Delete(slot.Key, false)
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.keepUpdateSlot(m, slot, stop)
+
+	surge, err := s.acquireUpdateSlot(m, slot.Key)
+	if err == nil {
+		mr := newManifestRunner(m, s.dockerClient, s.secrets)
+		if surge {
+			err = s.surge(mr)
+		} else {
+			err = s.recreate(mr)
+		}
+	}
+	if err == errUpdateAborted {
+		logFor(m).Op("update").Info("update aborted", "revision", m.Revision)
+		return err
+	}
+	if err != nil {
+		logFor(m).Op("update").Error("updating container failed", "surge", surge, "err", err)
+		s.setHostStatus(m, failedHost(err))
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.restarts.reset(m.Container.Name)
+	return s.setHostStatus(m, Host{Addr: hostIP, Status: hostStatusRunning})
+}
+
+// whileIncluded calls f holding the lock unless the conductor is stopping,
+// the manifest has been changed or this host has lost the slot of the
+// manifest in the meantime.
+func (s scheduler) whileIncluded(m *Manifest, f func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped() || s.superseded(m) {
+		return errUpdateAborted
+	}
+	included, _ := s.hostsIncluded(m)
+	if !included {
+		return errUpdateAborted
+	}
+	return f()
+}
+
+// superseded reports whether the manifest has been changed, e.g. rolled
+// back, since the update to m started. The update of the new value is
+// queued behind it.
+func (s scheduler) superseded(m *Manifest) bool {
+	resp, err := s.etcdClient.Get(m.ManifestKey(), false, false)
+	return err == nil && revision(resp.Node.Value) != m.Revision
+}
+
+// recreate replaces the old container with the new one and waits until
+// the new one gets ready.
+func (s scheduler) recreate(mr *manifestRunner) error {
+	m := mr.manifest
+	err := s.whileIncluded(m, func() error {
+		s.setHostStatus(m, Host{Addr: hostIP, Status: hostStatusCreating})
+		return mr.run()
+	})
+	if err != nil {
+		return err
+	}
+	return s.waitReady(m, m.Container.Name)
+}
+
+// surge starts the new container next to the old one and replaces the old
+// one after the new one gets ready.
+func (s scheduler) surge(mr *manifestRunner) error {
+	m := mr.manifest
+	name := m.Container.Name
+	next := name + surgeSuffix
+	var id DockerContainerID
+	err := s.whileIncluded(m, func() error {
+		s.setHostStatus(m, Host{Addr: hostIP, Status: hostStatusCreating})
+		s.dockerClient.RemoveContainer(docker.RemoveContainerOptions{
+			ID:    next,
+			Force: true,
+		})
+		var err error
+		id, err = mr.start(next)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	err = s.waitReady(m, string(id))
+	if err == nil {
+		err = s.whileIncluded(m, func() error {
+			return s.replace(mr, id)
+		})
+		if err != errUpdateAborted {
+			return err
+		}
+	}
+	s.dockerClient.RemoveContainer(docker.RemoveContainerOptions{
+		ID:    string(id),
+		Force: true,
+	})
+	return err
+}
+
+// replace removes the old container and renames the new one after it.
+func (s scheduler) replace(mr *manifestRunner, id DockerContainerID) error {
+	name := mr.manifest.Container.Name
+	s.removeContainer(mr.manifest)
+	err := s.dockerClient.RenameContainer(docker.RenameContainerOptions{
+		ID:   string(id),
+		Name: name,
+	})
+	if err != nil {
+		return err
+	}
+	if mr.manifest.Container.HealthCheck != nil {
+		// the health checker keeps announcing the new container
+		return nil
+	}
+	// the old container's services have been deleted on its death
+	return NewRegister(s.dockerClient, s.etcdClient).Add(id)
+}
+
+// createUpdateSlot queues this host for the update slots of the manifest.
+func (s scheduler) createUpdateSlot(m *Manifest) (*etcd.Node, error) {
+	value, _ := json.Marshal(Host{Addr: hostIP})
+	resp, err := s.etcdClient.CreateInOrder(m.UpdateDirKey(), string(value), hostLeaseTTL)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Node, nil
+}
+
+// keepUpdateSlot refreshes the TTL of the update slot until stop is closed,
+// so that the slot lasts as long as the update but is freed soon after
+// this host dies.
+func (s scheduler) keepUpdateSlot(m *Manifest, slot *etcd.Node, stop chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(heartbeatInterval()):
+		}
+		_, err := s.etcdClient.Update(slot.Key, slot.Value, hostLeaseTTL)
+		if err != nil {
+			logFor(m).Op("update").Warn("refreshing update slot failed", "key", slot.Key, "err", err)
+		}
+	}
+}
+
+// acquireUpdateSlot waits until this host is allowed to replace its
+// container. surge is true if the new container may be started before the
+// old one is stopped.
+func (s scheduler) acquireUpdateSlot(m *Manifest, key string) (surge bool, err error) {
+	p := m.Container.Update
+	deadline := time.Now().Add(time.Second * time.Duration(p.Timeout))
+	for {
+		pos, err := s.updateSlotPosition(m, key)
+		if err != nil {
+			return false, err
+		}
+		if pos < p.MaxSurge {
+			return true, nil
+		}
+		if pos < p.MaxSurge+p.MaxUnavailable {
+			return false, nil
+		}
+		if s.superseded(m) {
+			return false, errUpdateAborted
+		}
+		if time.Now().After(deadline) {
+			return false, errors.New("timed out waiting for an update slot")
+		}
+		err = s.sleep(updatePollInterval)
+		if err != nil {
+			return false, err
+		}
+	}
+}
+
+// sleep returns errUpdateAborted if the conductor stops in d.
+func (s scheduler) sleep(d time.Duration) error {
+	select {
+	case <-s.quit:
+		return errUpdateAborted
+	case <-time.After(d):
+		return nil
+	}
+}
+
+func (s scheduler) updateSlotPosition(m *Manifest, key string) (int, error) {
+	resp, err := s.etcdClient.Get(m.UpdateDirKey(), true, false)
+	if err != nil {
+		return 0, err
+	}
+	for i, n := range resp.Node.Nodes {
+		if n.Key == key {
+			return i, nil
+		}
+	}
+	return 0, errors.New("update slot expired: " + key)
+}
+
+// waitReady waits until the container keeps running, and passing its
+// health check if any, for MinReadySeconds.
+func (s scheduler) waitReady(m *Manifest, id string) error {
+	p := m.Container.Update
+	hc := m.Container.HealthCheck
+	minReady := time.Second * time.Duration(p.MinReadySeconds)
+	deadline := time.Now().Add(time.Second * time.Duration(p.Timeout))
+	var since time.Time
+	for {
+		c, err := s.dockerClient.InspectContainer(id)
+		if err != nil {
+			return err
+		}
+		if !c.State.Running {
+			return errors.New(m.Container.Name + " stopped while becoming ready")
+		}
+		healthy := hc == nil || hc.check(s.dockerClient, c) == nil
+		if !healthy {
+			since = time.Time{}
+		} else if since.IsZero() {
+			since = time.Now()
+		}
+		if healthy && time.Since(since) >= minReady {
+			return nil
+		}
+		if s.superseded(m) {
+			return errUpdateAborted
+		}
+		if time.Now().After(deadline) {
+			return errors.New(m.Container.Name + " did not become ready in time")
+		}
+		err = s.sleep(updatePollInterval)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// runningRevision returns the manifest revision of the running container.
+func (s scheduler) runningRevision(m *Manifest) string {
+	c, err := s.dockerClient.InspectContainer(m.Container.Name)
+	if err != nil || !c.State.Running {
+		return ""
+	}
+	return containerEnv(c, "DOKKAA_REVISION")
+}
+
+func containerEnv(c *docker.Container, key string) string {
+	if c.Config == nil {
+		return ""
+	}
+	for _, e := range c.Config.Env {
+		parts := strings.SplitN(e, "=", 2)
+		if len(parts) == 2 && parts[0] == key {
+			return parts[1]
+		}
+	}
+	return ""
+}
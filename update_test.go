@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestWaitReadyUnhealthy(t *testing.T) {
+	defer func(d time.Duration) { updatePollInterval = d }(updatePollInterval)
+	updatePollInterval = 10 * time.Millisecond
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	l.Close()
+
+	c := runningContainer("blog---web")
+	c.NetworkSettings = containerWithPort("5432", port).NetworkSettings
+	s := NewScheduler(newFakeDocker(c), newFakeEtcd()).(*scheduler)
+	m := &Manifest{
+		AppName:       "blog",
+		ContainerName: "web",
+		Container: &Container{
+			Name:        "blog---web",
+			Update:      &UpdatePolicy{Strategy: "rolling", Timeout: 1},
+			HealthCheck: &HealthCheck{Type: healthCheckTCP, Port: 5432, Timeout: 1},
+		},
+	}
+	err = s.waitReady(m, "blog---web")
+	if err == nil || !strings.Contains(err.Error(), "did not become ready") {
+		t.Error(err)
+	}
+
+	m.Container.HealthCheck = nil
+	err = s.waitReady(m, "blog---web")
+	if err != nil {
+		t.Error(err)
+	}
+
+	c.State = docker.State{Running: false}
+	err = s.waitReady(m, "blog---web")
+	if err == nil || !strings.Contains(err.Error(), "stopped") {
+		t.Error(err)
+	}
+}
+
+func TestAcquireUpdateSlot(t *testing.T) {
+	defer func(d time.Duration) { updatePollInterval = d }(updatePollInterval)
+	updatePollInterval = 10 * time.Millisecond
+
+	e := newFakeEtcd()
+	s := NewScheduler(newFakeDocker(), e).(*scheduler)
+	m, _ := NewManifest("blog", "web", `{"Image": "nginx", "Update": {"Strategy": "rolling", "MaxSurge": 1, "MaxUnavailable": 1, "Timeout": 1}}`)
+
+	var slots []string
+	for i := 0; i < 3; i++ {
+		slot, err := s.createUpdateSlot(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if slot.TTL != int64(hostLeaseTTL) {
+			t.Error(slot.TTL)
+		}
+		slots = append(slots, slot.Key)
+	}
+	surge, err := s.acquireUpdateSlot(m, slots[0])
+	if err != nil || !surge {
+		t.Error(surge, err)
+	}
+	surge, err = s.acquireUpdateSlot(m, slots[1])
+	if err != nil || surge {
+		t.Error(surge, err)
+	}
+	_, err = s.acquireUpdateSlot(m, slots[2])
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Error(err)
+	}
+
+	// a slot is freed while waiting
+	time.AfterFunc(50*time.Millisecond, func() { e.Delete(slots[0], false) })
+	surge, err = s.acquireUpdateSlot(m, slots[2])
+	if err != nil || surge {
+		t.Error(surge, err)
+	}
+
+	e.Delete(slots[1], false)
+	_, err = s.acquireUpdateSlot(m, slots[1])
+	if err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Error(err)
+	}
+}
+
+func TestKeepUpdateSlot(t *testing.T) {
+	defer func(ttl uint64) { hostLeaseTTL = ttl }(hostLeaseTTL)
+	hostLeaseTTL = 1
+
+	e := newFakeEtcd()
+	s := NewScheduler(newFakeDocker(), e).(*scheduler)
+	m, _ := NewManifest("blog", "web", `{"Image": "nginx"}`)
+	slot, _ := s.createUpdateSlot(m)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.keepUpdateSlot(m, slot, stop)
+		close(done)
+	}()
+	time.Sleep(heartbeatInterval() * 3 / 2)
+	resp, err := e.Get(slot.Key, false, false)
+	if err != nil || resp.Node.ModifiedIndex == slot.ModifiedIndex {
+		t.Error("slot must be refreshed", err)
+	}
+	e.Delete(slot.Key, false)
+	time.Sleep(heartbeatInterval())
+	if _, err := e.Get(slot.Key, false, false); err == nil {
+		t.Error("slot must not be created again")
+	}
+	close(stop)
+	<-done
+}
+
+// rollingUpdateScheduler returns a scheduler holding the slot of a running
+// blog---web of an old revision, and its manifest updated with policy.
+func rollingUpdateScheduler(policy string) (*scheduler, *fakeDocker, *fakeEtcd, *Manifest) {
+	val := `{"Image": "nginx:1.9", "Update": ` + policy + `}`
+	e := newFakeEtcd()
+	e.Set("/hosts/"+hostIP+"/alive", "", hostLeaseTTL)
+	e.Set("/apps/blog/web/manifest", val, 0)
+	e.CreateInOrder("/apps/blog/web/hosts", hostEntry(hostStatusRunning), hostLeaseTTL)
+	d := newFakeDocker(runningContainer("blog---web", "DOKKAA_REVISION=old"))
+	m, _ := NewManifest("blog", "web", val)
+	return NewScheduler(d, e).(*scheduler), d, e, m
+}
+
+func waitUpdated(t *testing.T, s *scheduler, name string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for s.updates.updating(name) {
+		if time.Now().After(deadline) {
+			t.Fatal("update did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRollingUpdateSurge(t *testing.T) {
+	defer func(ip string) { hostIP = ip }(hostIP)
+	hostIP = "10.0.0.1"
+
+	s, d, e, m := rollingUpdateScheduler(`{"Strategy": "rolling", "MaxSurge": 1, "Timeout": 5}`)
+	s.tryAcquire(m)
+	waitUpdated(t, s, "blog---web")
+
+	if rev := s.runningRevision(m); rev != m.Revision {
+		t.Error(rev)
+	}
+	if _, err := d.InspectContainer("blog---web" + surgeSuffix); err == nil {
+		t.Error("surge container must be renamed")
+	}
+	var h Host
+	json.Unmarshal([]byte(s.ownHostNodes(m)[0].Value), &h)
+	if h.Status != hostStatusRunning {
+		t.Error(h.Status)
+	}
+	if _, err := e.Get(m.UpdateDirKey(), false, true); err == nil {
+		t.Error("update slot must be deleted")
+	}
+}
+
+func TestRollingUpdateUnlocked(t *testing.T) {
+	defer func(ip string) { hostIP = ip }(hostIP)
+	defer func(d time.Duration) { updatePollInterval = d }(updatePollInterval)
+	hostIP = "10.0.0.1"
+	updatePollInterval = 10 * time.Millisecond
+
+	// the new container never passes its health check
+	s, d, e, m := rollingUpdateScheduler(`{"Strategy": "rolling", "MaxSurge": 1, "Timeout": 60}`)
+	m.Container.HealthCheck = &HealthCheck{Type: healthCheckTCP, Port: 80, Timeout: 1}
+	s.tryAcquire(m)
+	next := "blog---web" + surgeSuffix
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := d.InspectContainer(next); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("surge container is not started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// waiting for the new container doesn't block other operations
+	locked := make(chan struct{})
+	go func() {
+		s.mu.Lock()
+		s.mu.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("the lock is held while waiting")
+	}
+	s.Reconcile()
+	if _, err := d.InspectContainer(next); err != nil {
+		t.Error("reconcile must not remove the container being updated")
+	}
+
+	// stopping aborts the update without failing the replica
+	start := time.Now()
+	s.Stop()
+	if time.Since(start) > 5*time.Second {
+		t.Error("stop waited for the update timeout")
+	}
+	if _, err := d.InspectContainer(next); err == nil {
+		t.Error("surge container must be removed")
+	}
+	if rev := s.runningRevision(m); rev != "old" {
+		t.Error(rev)
+	}
+	var h Host
+	json.Unmarshal([]byte(s.ownHostNodes(m)[0].Value), &h)
+	if h.Status == hostStatusFailed {
+		t.Error(h)
+	}
+	if _, err := e.Get(m.UpdateDirKey(), false, true); err == nil {
+		t.Error("update slot must be deleted")
+	}
+}
+
+func TestRollingUpdateSuperseded(t *testing.T) {
+	defer func(ip string) { hostIP = ip }(hostIP)
+	defer func(d time.Duration) { updatePollInterval = d }(updatePollInterval)
+	hostIP = "10.0.0.1"
+	updatePollInterval = 10 * time.Millisecond
+
+	// the new container never passes its health check
+	s, d, e, m := rollingUpdateScheduler(`{"Strategy": "rolling", "MaxSurge": 1, "Timeout": 60}`)
+	m.Container.HealthCheck = &HealthCheck{Type: healthCheckTCP, Port: 80, Timeout: 1}
+	s.tryAcquire(m)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := d.InspectContainer("blog---web" + surgeSuffix); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("surge container is not started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the manifest is rolled back while waiting
+	val := `{"Image": "nginx:1.7", "Update": {"Strategy": "rolling", "MaxSurge": 1, "Timeout": 60}}`
+	e.Set(m.ManifestKey(), val, 0)
+	rolledBack, _ := NewManifest("blog", "web", val)
+	s.mu.Lock()
+	s.tryAcquire(rolledBack)
+	s.mu.Unlock()
+	start := time.Now()
+	waitUpdated(t, s, "blog---web")
+	if time.Since(start) > 5*time.Second {
+		t.Error("the superseded update waited for its timeout")
+	}
+
+	if rev := s.runningRevision(m); rev != rolledBack.Revision {
+		t.Error(rev)
+	}
+	var h Host
+	json.Unmarshal([]byte(s.ownHostNodes(m)[0].Value), &h)
+	if h.Status != hostStatusRunning || h.Revision != rolledBack.Revision {
+		t.Error(h)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	manifestAccepted = "accepted"
+	manifestRejected = "rejected"
+)
+
+// FieldError is a problem of a field of a manifest.
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ValidationError lists all problems found in a manifest.
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	var msgs []string
+	for _, fe := range e {
+		msgs = append(msgs, fe.Error())
+	}
+	return "invalid manifest: " + strings.Join(msgs, ", ")
+}
+
+// ManifestStatus is written to /apps/<app>/<container>/status so that the
+// author of a manifest can see whether it was accepted.
+type ManifestStatus struct {
+	Status   string   `json:"status"`
+	Revision string   `json:"revision,omitempty"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+type validator struct {
+	errs ValidationError
+}
+
+func (v *validator) check(ok bool, field, format string, args ...interface{}) {
+	if !ok {
+		v.errs = append(v.errs, FieldError{field, fmt.Sprintf(format, args...)})
+	}
+}
+
+func validPort(port int) bool {
+	return port > 0 && port <= 65535
+}
+
+func validProtocol(proto string) bool {
+	return proto == "tcp" || proto == "udp"
+}
+
+// publishesTCP reports whether the container port is published over tcp
+// by a service or a host port binding.
+func (c *Container) publishesTCP(port int) bool {
+	for _, s := range c.Services {
+		if s.Port == port && s.Protocol == "tcp" {
+			return true
+		}
+	}
+	for _, p := range c.Ports {
+		if p.ContainerPort == port && p.Protocol == "tcp" {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the manifest doesn't have values which would fail later
+// inside docker or the scheduler.
+func (m *Manifest) Validate() error {
+	v := &validator{}
+	c := m.Container
+
+	v.check(c.Image != "", "Image", "must not be empty")
+	v.check(c.Scale > 0, "Scale", "must be positive")
+
+	servicePorts := map[string]string{}
+	for name, s := range c.Services {
+		field := "Services." + name
+		v.check(validPort(s.Port), field+".Port", "%d is out of range", s.Port)
+		v.check(validProtocol(s.Protocol), field+".Protocol", "must be tcp or udp")
+		port := strconv.Itoa(s.Port) + "/" + s.Protocol
+		if other, ok := servicePorts[port]; ok {
+			v.check(false, field+".Port", "%s is also used by %s", port, other)
+		}
+		servicePorts[port] = name
+	}
+
+	for i, p := range c.Ports {
+		field := fmt.Sprintf("Ports[%d]", i)
+		v.check(validPort(p.ContainerPort), field+".ContainerPort", "%d is out of range", p.ContainerPort)
+		v.check(validPort(p.HostPort), field+".HostPort", "%d is out of range", p.HostPort)
+		v.check(validProtocol(p.Protocol), field+".Protocol", "must be tcp or udp")
+		for j := 0; j < i; j++ {
+			v.check(!p.conflicts(c.Ports[j]), field+".HostPort", "%d is also bound by Ports[%d]", p.HostPort, j)
+		}
+	}
+
+	for i, l := range c.Links {
+		v.check(l != "" && !strings.Contains(l, "/"), fmt.Sprintf("Links[%d]", i), "must be a container name of the app")
+	}
+
+	if u := c.Update; u != nil {
+		v.check(u.Strategy == updateStrategyRecreate || u.Strategy == updateStrategyRolling, "Update.Strategy", "must be recreate or rolling")
+		v.check(u.MaxUnavailable >= 0, "Update.MaxUnavailable", "must not be negative")
+		v.check(u.MaxSurge >= 0, "Update.MaxSurge", "must not be negative")
+		// a new replica can't bind the host ports the old one still holds
+		v.check(u.MaxSurge == 0 || len(c.Ports) == 0, "Update.MaxSurge", "must be 0 with fixed host Ports")
+		v.check(u.MinReadySeconds >= 0, "Update.MinReadySeconds", "must not be negative")
+		v.check(u.Timeout > 0, "Update.Timeout", "must be positive")
+	}
+	if r := c.Rollback; r != nil {
+		v.check(r.FailureRatio > 0 && r.FailureRatio <= 1, "Rollback.FailureRatio", "must be in (0, 1]")
+	}
+
+	if hc := c.HealthCheck; hc != nil {
+		switch hc.Type {
+		case healthCheckHTTP, healthCheckTCP:
+			v.check(validPort(hc.Port), "HealthCheck.Port", "%d is out of range", hc.Port)
+			// the check connects to the host port the port is published on
+			v.check(c.publishesTCP(hc.Port), "HealthCheck.Port", "%d/tcp must be published by Services or Ports", hc.Port)
+		case healthCheckExec:
+			v.check(len(hc.Command) > 0, "HealthCheck.Command", "must not be empty")
+		default:
+			v.check(false, "HealthCheck.Type", "must be http, tcp or exec")
+		}
+		v.check(hc.Interval > 0, "HealthCheck.Interval", "must be positive")
+		v.check(hc.Timeout > 0, "HealthCheck.Timeout", "must be positive")
+		v.check(hc.Retries > 0, "HealthCheck.Retries", "must be positive")
+	}
+
+	switch c.Restart.Policy {
+	case restartAlways, restartOnFailure, restartNever:
+	default:
+		v.check(false, "Restart.Policy", "must be always, on-failure or never")
+	}
+	v.check(c.Restart.MaxRetries >= 0, "Restart.MaxRetries", "must not be negative")
+
+	_, err := NewPlacementStrategy(c.Placement)
+	v.check(err == nil, "Placement", "must be spread, binpack or random")
+
+	for i, con := range c.Constraints {
+		field := fmt.Sprintf("Constraints[%d]", i)
+		v.check(con.Label != "", field+".Label", "must not be empty")
+		switch con.Op {
+		case "", constraintEquals, constraintIn, constraintNotIn:
+		default:
+			v.check(false, field+".Op", "must be equals, in or not-in")
+		}
+	}
+
+	if r := c.Resources; r != nil {
+		v.check(r.CPU >= 0, "Resources.cpu", "must not be negative")
+		v.check(r.Memory >= 0, "Resources.memory", "must not be negative")
+	}
+	if l := c.Limits; l != nil {
+		v.check(l.Memory >= 0, "Limits.Memory", "must not be negative")
+		v.check(l.CPUShares >= 0, "Limits.CPUShares", "must not be negative")
+		v.check(l.CPUQuota >= 0, "Limits.CPUQuota", "must not be negative")
+		v.check(l.CPUPeriod >= 0, "Limits.CPUPeriod", "must not be negative")
+		v.check(l.PidsLimit >= 0, "Limits.PidsLimit", "must not be negative")
+	}
+
+	for i, vol := range c.Volumes {
+		field := fmt.Sprintf("Volumes[%d]", i)
+		v.check(filepath.IsAbs(vol.Target), field+".Target", "must be an absolute path")
+		switch vol.Type {
+		case volumeTypeVolume:
+			v.check(vol.Source != "", field+".Source", "must not be empty")
+		case volumeTypeBind:
+			v.check(filepath.IsAbs(vol.Source), field+".Source", "must be an absolute path")
+		case volumeTypeTmpfs:
+		default:
+			v.check(false, field+".Type", "must be volume, bind or tmpfs")
+		}
+	}
+
+	for i, ref := range c.Secrets {
+		field := fmt.Sprintf("Secrets[%d]", i)
+		v.check(ref.Name != "" && !strings.Contains(ref.Name, "/"), field+".Name", "must be a secret name")
+		v.check(ref.Env != "" || ref.File != "", field, "must have Env or File")
+		v.check(ref.File == "" || filepath.IsAbs(ref.File), field+".File", "must be an absolute path")
+		_, generated := c.Env[ref.Env]
+		v.check(!generated, field+".Env", "%s conflicts with another variable", ref.Env)
+	}
+
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+	return nil
+}
+
+// validate checks the manifest including links to other containers of the app.
+func (s scheduler) validate(m *Manifest) error {
+	err := m.Validate()
+	errs, _ := err.(ValidationError)
+	if err != nil && errs == nil {
+		return err
+	}
+	for i, l := range m.Container.Links {
+		_, err := s.getManifest(m.AppName, l)
+		if err != nil && isKeyNotFound(err) {
+			errs = append(errs, FieldError{fmt.Sprintf("Links[%d]", i), l + " doesn't exist in " + m.AppName})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// setManifestStatus writes whether the manifest is accepted, and why not
+// if err is not nil.
+func (s scheduler) setManifestStatus(m *Manifest, err error) error {
+	status := ManifestStatus{
+		Status:   manifestAccepted,
+		Revision: m.Revision,
+	}
+	if err != nil {
+		status.Status = manifestRejected
+		if errs, ok := err.(ValidationError); ok {
+			for _, fe := range errs {
+				status.Errors = append(status.Errors, fe.Error())
+			}
+		} else {
+			status.Errors = []string{err.Error()}
+		}
+	}
+	value, _ := json.Marshal(status)
+	_, err = s.etcdClient.Set(m.StatusKey(), string(value), 0)
+	return err
+}
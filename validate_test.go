@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	m, err := NewManifest("app", "web", `{
+		"Image": "nginx",
+		"Services": {"http": {"Port": 80}, "dns": {"Port": 53, "Protocol": "udp"}},
+		"Ports": [{"HostPort": 80, "ContainerPort": 80}],
+		"Update": {"Strategy": "rolling"},
+		"HealthCheck": {"Type": "http", "Port": 80, "Path": "/"},
+		"Volumes": [{"Source": "data", "Target": "/data"}]
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidateErrors(t *testing.T) {
+	expects := map[string]string{
+		`{}`:                              "Image",
+		`{"Image": "nginx", "Scale": -1}`: "Scale",
+		`{"Image": "nginx", "Services": {"a": {"Port": 80}, "b": {"Port": 80}}}`:                                        ".Port",
+		`{"Image": "nginx", "Services": {"a": {"Port": 70000}}}`:                                                        "Services.a.Port",
+		`{"Image": "nginx", "Services": {"a": {"Port": 53, "Protocol": "sctp"}}}`:                                       "Services.a.Protocol",
+		`{"Image": "nginx", "Ports": [{"HostPort": 80, "ContainerPort": 80}, {"HostPort": 80, "ContainerPort": 8080}]}`: "Ports[1].HostPort",
+		`{"Image": "nginx", "Update": {"Strategy": "blue-green"}}`:                                                      "Update.Strategy",
+		`{"Image": "nginx", "Ports": [{"HostPort": 80, "ContainerPort": 80}], "Update": {"MaxSurge": 1}}`:               "Update.MaxSurge",
+		`{"Image": "nginx", "HealthCheck": {"Type": "exec"}}`:                                                           "HealthCheck.Command",
+		`{"Image": "x", "Services": {"d": {"Port": 9, "Protocol": "udp"}}, "HealthCheck": {"Type": "tcp", "Port": 9}}`:  "HealthCheck.Port",
+		`{"Image": "nginx", "HealthCheck": {"Type": "http", "Port": 8080, "Path": "/"}}`:                                "HealthCheck.Port",
+		`{"Image": "nginx", "Restart": {"Policy": "sometimes"}}`:                                                        "Restart.Policy",
+		`{"Image": "nginx", "Placement": "everywhere"}`:                                                                 "Placement",
+		`{"Image": "nginx", "Constraints": [{"Op": "in"}]}`:                                                             "Constraints[0].Label",
+		`{"Image": "nginx", "Volumes": [{"Target": "data"}]}`:                                                           "Volumes[0].Target",
+		`{"Image": "nginx", "Secrets": [{"Name": "db"}]}`:                                                               "Secrets[0]",
+		`{"Image": "nginx", "Limits": {"Memory": -1}}`:                                                                  "Limits.Memory",
+		`{"Image": "nginx", "Links": ["other/db"]}`:                                                                     "Links[0]",
+	}
+	for val, field := range expects {
+		m, err := NewManifest("app", "web", val)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = m.Validate()
+		errs, ok := err.(ValidationError)
+		if !ok {
+			t.Error("must be invalid: ", val)
+			continue
+		}
+		found := false
+		for _, fe := range errs {
+			if strings.HasPrefix(fe.Field, field) || strings.HasSuffix(fe.Field, field) {
+				found = true
+			}
+		}
+		if !found {
+			t.Error(field, errs)
+		}
+	}
+}